@@ -5,6 +5,7 @@ import (
 	"os"
 	"fmt"
 	"strings"
+	"unicode"
 
 	"crypto/md5"
 	"encoding/hex"
@@ -22,15 +23,44 @@ var (
 )
 
 // generates filename based on: hash(url)
+// or the remark of the URL, when opt.remark_names is set
 func (opt Opt) gen_output_filepath(url []byte) string {
 	h := md5.New()
 	h.Write(url)
-	return filepath.Join (opt.output_dir,
-		fmt.Sprintf(
-			"config_%s.json",
-			hex.EncodeToString(h.Sum(nil))[0:16],
-		),
-	)
+	hash := hex.EncodeToString(h.Sum(nil))[0:16]
+
+	name := ""
+	if opt.remark_names {
+		name = sanitize_filename(opt.v2.Remark())
+	}
+	if "" == name {
+		name = "config_" + hash
+	} else if opt.used_names[name] {
+		name += "_" + hash[0:8] // duplicate remark
+	}
+	opt.used_names[name] = true
+	ext := ".json"
+	if "" != opt.out_format {
+		ext = "." + opt.out_format
+	}
+	return filepath.Join (opt.output_dir, name + ext)
+}
+
+// Makes remarks usable as filename
+// Keeps letters, digits and `-_.@`, replaces the others by `_`
+func sanitize_filename(name string) string {
+	var res []rune
+	for _, c := range name {
+		if unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("-_.@", c) {
+			res = append(res, c)
+		} else if len(res) > 0 && '_' != res[len(res)-1] {
+			res = append(res, '_')
+		}
+		if len(res) >= 64 {
+			break;
+		}
+	}
+	return strings.Trim(string(res), "._")
 }
 
 // Applies opt.template_path  or  the default template
@@ -40,10 +70,13 @@ func (opt *Opt) Init_CFG() error {
 		if Stdin_is_tty {
 			println ("Reading json config from STDIN until EOF:")
 		}
-		return opt.v2.Apply_template_byio (os.Stdin);
+		if opt.url_cmd() {
+			return opt.v2.Apply_template_byio (os.Stdin);
+		}
+		return opt.v2.Load_CFG_byio (os.Stdin);
 	} else {
 		if "" != opt.cfg {
-			return opt.v2.Apply_template (opt.cfg)
+			return opt.Apply_template();
 		} else {
 			opt.Apply_Default_Template();
 		}
@@ -51,12 +84,26 @@ func (opt *Opt) Init_CFG() error {
 	return nil
 }
 
-func result2string(result *pkg.TestResult) string {
+func (opt Opt) result2string(result *pkg.TestResult) string {
+	res := ""
 	if "" != result.IP {
-		return fmt.Sprintf("[IP: %s] (%dms)", result.IP, result.Duration);
+		res += fmt.Sprintf("[IP: %s] ", result.IP);
+	}
+	if "" != result.IPv6 {
+		res += fmt.Sprintf("[IPv6: %s] ", result.IPv6);
+	} else if opt.ipv6 {
+		res += "[IPv6: none] "
+	}
+	if st := result.Stats; nil != st {
+		res += fmt.Sprintf("(%dms, then min/median/p95: %d/%d/%dms, jitter: %dms, loss: %.0f%% of %d)",
+			result.Duration, st.Min, st.Median, st.P95, st.Jitter, st.Loss, st.Rounds);
 	} else {
-		return fmt.Sprintf("(%dms)", result.Duration);
+		res += fmt.Sprintf("(%dms)", result.Duration);
 	}
+	if p := result.Phases; nil != p {
+		res += fmt.Sprintf(" [dial: %dms, tls: %dms, first byte: %dms]", p.Dial, p.TLS, p.FirstByte);
+	}
+	return res
 }
 
 // makes long xray error messages shorter
@@ -73,7 +120,10 @@ func shortError(err string) string {
 }
 
 func (opt *Opt) get_contester() pkg.ConnectivityTester_I {
-	if opt.verbose {
+	if opt.ipv6 {
+		// To also check IPv6 of VPN
+		return pkg.IPv6Tester;
+	} else if opt.verbose {
 		// To also get the IP address of VPN
 		return pkg.AdvancedTester;
 	} else {
@@ -84,9 +134,13 @@ func (opt *Opt) get_contester() pkg.ConnectivityTester_I {
 
 func (opt *Opt) Test_CFG() (bool) {
 	err, result := opt.v2.Test_CFG(opt.cfg, opt.get_contester());
+	return opt.report_CFG(err, result);
+}
 
+// Reports the test result of opt.cfg
+func (opt *Opt) report_CFG(err error, result *pkg.TestResult) (bool) {
 	if nil == err && opt.verbose {
-		log.Infof("File '%s':  %s OK.\n", opt.cfg, result2string(result));
+		log.Infof("File '%s':  %s OK.\n", opt.cfg, opt.result2string(result));
 	}
 	if ! opt.reverse {
 		if nil == err {
@@ -101,10 +155,18 @@ func (opt *Opt) Test_CFG() (bool) {
 }
 
 func (opt *Opt) Test_URL() (bool) {
+	if opt.chain {
+		err, result := opt.v2.Test_Chain(opt.url, opt.get_contester());
+		return opt.report_URL(err, result);
+	}
 	err, result := opt.v2.Test_URL(opt.url, opt.get_contester());
+	return opt.report_URL(err, result);
+}
 
+// Reports the test result of opt.url
+func (opt *Opt) report_URL(err error, result *pkg.TestResult) (bool) {
 	if nil == err && opt.verbose {
-		log.Infof("URL '%s':  %s OK.\n", opt.url, result2string(result));
+		log.Infof("URL '%s':  %s OK.\n", opt.url, opt.result2string(result));
 	}
 	if ! opt.reverse {
 		if nil == err {
@@ -118,14 +180,100 @@ func (opt *Opt) Test_URL() (bool) {
 	return (nil == err);
 }
 
+// Removes opt.cfg, if it's broken and the --rm option is set
+func (opt *Opt) rm_broken_CFG(res bool) {
+	if !res && opt.rm { // We are not in reverse mode here
+		if e := os.Remove(opt.cfg); nil != e {
+			log.Errorf("Could not remove %s - %v\n", opt.cfg, e)
+		} else {
+			log.Logf("Broken file %s was removed.\n", opt.cfg)
+		}
+	}
+}
+
+// Generates json output of opt.url, if it's functional
+// @return:  negative on fatal failures
+func (opt *Opt) output_functional_URL(res bool) int {
+	if res && nil != opt.proxies {
+		if e := opt.proxies.Add_URL(opt.url); nil != e {
+			log.Warnf("Could not convert URL '%s' to %s - %v\n", opt.url, opt.format, e);
+		}
+	} else if res && "" != opt.output_dir {
+		if e := opt.MK_josn_output(opt.url); nil != e {
+			log.Errorf("IO error: %v\n", e);
+			log.Errorf("Fatal error, exiting.\n");
+			return -1;
+		}
+	}
+	return 0;
+}
+
+// Handles a finished job of the test pool
+// @return:  negative on fatal failures
+func (opt *Opt) Done_TestJob(job *pkg.TestJob) int {
+	switch (job.Type) {
+	case pkg.TestJob_URL, pkg.TestJob_Chain:
+		opt.url = job.Input
+		opt.v2.CFG = job.CFG
+		res := opt.report_URL(job.Err, job.Result)
+		return opt.output_functional_URL(res);
+
+	case pkg.TestJob_CFG:
+		opt.cfg = job.Input
+		res := opt.report_CFG(job.Err, job.Result)
+		opt.rm_broken_CFG(res);
+		break;
+	}
+	return 0;
+}
+
 func (opt *Opt) Apply_URL() error {
+	if opt.server {
+		return opt.v2.Init_Inbound_byURL(opt.url, opt.server_opts);
+	}
+	if opt.chain {
+		return opt.v2.Init_Outbound_byChain(pkg.Split_Chain(opt.url));
+	}
 	return opt.v2.Apply_URL(opt.url);
 }
 func (opt *Opt) Init_Outbound_byURL() error {
+	if opt.server {
+		return opt.v2.Init_Inbound_byURL(opt.url, opt.server_opts);
+	}
+	if opt.chain {
+		return opt.v2.Init_Outbound_byChain(pkg.Split_Chain(opt.url));
+	}
 	return opt.v2.Init_Outbound_byURL(opt.url);
 }
+// Checks the config of @input URL(s) by xray, unless opt.no_validate
+// Invalid configs are reported here
+func (opt Opt) Validate(input string) error {
+	if opt.no_validate {
+		return nil
+	}
+	e := opt.v2.Validate(input);
+	if nil != e {
+		log.Warnf("Invalid config of URL '%s' - %v\n", input, e);
+	}
+	return e
+}
+
+// Whether opt.cfg is a template (--template of the URL commands)
+func (opt Opt) url_cmd() bool {
+	switch (opt.cmd) {
+	case CMD_CONVERT_URL, CMD_RUN_URL, CMD_GEN:
+		return true
+	}
+	return false
+}
+
+// Merges opt.templates for the URL commands, or loads config opt.cfg
+// Only templates have variables, configs are loaded as is
 func (opt *Opt) Apply_template() error {
-	return opt.v2.Apply_template(opt.cfg);
+	if opt.url_cmd() {
+		return opt.v2.Apply_templates(opt.templates);
+	}
+	return opt.v2.Load_CFG(opt.cfg);
 }
 
 func (opt *Opt) Apply_Default_Template() {
@@ -136,6 +284,9 @@ func (opt *Opt) Apply_Default_Template() {
 }
 
 func (opt Opt) Get_Default_Template() string {
+	if opt.server {
+		return pkg.DEF_Server_Template;
+	}
 	switch (opt.cmd) {
 	case CMD_RUN_URL, CMD_RUN_CFG:
 		return pkg.DEF_Run_Template;
@@ -147,9 +298,35 @@ func (opt Opt) Get_Default_Template() string {
 	return "";
 }
 
+// Downloads subscriptions opt.subs, and appends their URLs to opt.urls
+// It goes through opt.sub_proxy (URL or config file) if it's provided
+func (opt *Opt) fetch_subscriptions() error {
+	v2 := &pkg.V2utils{}
+	if "" != opt.sub_proxy {
+		if e := v2.Run_Bootstrap(opt.sub_proxy); nil != e {
+			return e
+		}
+		defer v2.Kill_Xray()
+	}
+	for _, sub := range opt.subs {
+		body, info, e := v2.Fetch_Subscription(sub)
+		if nil != e {
+			log.Errorf("Could not fetch subscription '%s' - %v\n", sub, e);
+			continue;
+		}
+		urls := pkg.Decode_Subscription(body)
+		log.Infof("Subscription '%s':  %d URLs\n", sub, len(urls));
+		if nil != info {
+			log.Logf("Subscription '%s':  %s\n", sub, info);
+		}
+		opt.urls = append(opt.urls, urls...)
+	}
+	return nil
+}
+
 func (opt Opt) MK_josn_output(url string) error {
 	if "" == opt.output_dir {
-		if err := opt.v2.CFG_Out(os.Stdout, !Stdout_is_tty); nil != err {
+		if err := opt.v2.CFG_Out_Format(os.Stdout, opt.out_format, !Stdout_is_tty); nil != err {
 			return err;
 		}
 	} else {
@@ -163,7 +340,7 @@ func (opt Opt) MK_josn_output(url string) error {
 			return err
 		}
 		defer of.Close()
-		if err := opt.v2.CFG_Out(of, true); nil != err {
+		if err := opt.v2.CFG_Out_Format(of, opt.out_format, true); nil != err {
 			return err
 		} else {
 			log.Verbosef("Wrote: %s\n", path)
@@ -171,3 +348,36 @@ func (opt Opt) MK_josn_output(url string) error {
 	}
 	return nil;
 }
+
+// Writes the collected proxies (--format clash, singbox)
+// on stdout, or opt.proxies_file in opt.output_dir
+func (opt Opt) MK_proxies_output() {
+	if nil == opt.proxies {
+		return
+	}
+	if 0 == opt.proxies.Len() {
+		log.Warnf("No proxy was converted to %s.\n", opt.format);
+		return
+	}
+	if "" == opt.output_dir {
+		if e := opt.proxies.Out(os.Stdout); nil != e {
+			log.Errorf("IO error: %v\n", e);
+		}
+		return
+	}
+	path := filepath.Join (opt.output_dir, opt.proxies_file)
+	of, err := os.OpenFile(
+		path,
+		os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644,
+	);
+	if err != nil {
+		log.Errorf("IO error: %v\n", err);
+		return
+	}
+	defer of.Close()
+	if e := opt.proxies.Out(of); nil != e {
+		log.Errorf("IO error: %v\n", e);
+	} else {
+		log.Verbosef("Wrote: %s\n", path)
+	}
+}
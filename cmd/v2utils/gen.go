@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package main
+
+import (
+	"os"
+	"fmt"
+	"net"
+	"strconv"
+
+	log "github.com/siamak-amo/v2utils/log"
+	pkg "github.com/siamak-amo/v2utils/pkg"
+	internal "github.com/siamak-amo/v2utils/internal"
+)
+
+// Default port of the generated servers
+const DEF_Gen_Port = 443
+
+func (opt *Opt) Set2_gen(args []string) int {
+	opt.cmd = CMD_GEN;
+	opt.server = true // server options and template
+	for _, arg := range args {
+		if 0 != len(arg) && '-' == arg[0] {
+			break;
+		}
+		opt.gen_args = append(opt.gen_args, arg)
+	}
+	if 0 == len(opt.gen_args) {
+		log.Errorf("gen: missing what to generate (uuid, x25519, shortid, ss-key, server)\n");
+		return -1
+	}
+	return 0;
+}
+
+// Generates credentials or a new server, by opt.gen_args
+// @return:  0 on success, positive on failures
+func (opt *Opt) Do_gen() int {
+	arg := ""
+	if 1 < len(opt.gen_args) {
+		arg = opt.gen_args[1]
+	}
+	switch (opt.gen_args[0]) {
+	case "uuid", "id":
+		fmt.Println(internal.Gen_UUID());
+		break;
+
+	case "x25519", "reality", "key":
+		private, public, e := internal.Gen_x25519();
+		if nil != e {
+			log.Errorf("gen: %v\n", e);
+			return 1
+		}
+		fmt.Printf("PrivateKey: %s\nPublicKey: %s\n", private, public);
+		break;
+
+	case "shortid", "sid":
+		n := internal.ShortID_Length
+		if "" != arg {
+			var e error
+			if n, e = strconv.Atoi(arg); nil != e {
+				log.Errorf("gen: invalid short ID length '%s'\n", arg);
+				return 1
+			}
+		}
+		sid, e := internal.Gen_ShortID(n);
+		if nil != e {
+			log.Errorf("gen: %v\n", e);
+			return 1
+		}
+		fmt.Println(sid);
+		break;
+
+	case "ss-key", "ss":
+		if "" == arg {
+			arg = internal.DEF_SS_Method
+		}
+		key, e := internal.Gen_SS_Password(arg);
+		if nil != e {
+			log.Errorf("gen: %v\n", e);
+			return 1
+		}
+		fmt.Println(key);
+		break;
+
+	case "server":
+		return opt.gen_server(arg);
+
+	default:
+		log.Errorf("gen: cannot generate '%s'\n", opt.gen_args[0]);
+		return 1
+	}
+	return 0
+}
+
+// Makes server config of a new server of @kind, and prints its URL
+// The config goes to opt.output_dir (or stdout), then the URL is
+// printed on stdout (or stderr, if the config is on stdout)
+func (opt *Opt) gen_server(kind string) int {
+	if "" == kind {
+		kind = pkg.Server_Kinds[0]
+	}
+	if "" == opt.public_host {
+		log.Errorf("gen server: --public-host is required\n");
+		return 1
+	}
+	host, port := opt.public_host, DEF_Gen_Port
+	if h, p, e := net.SplitHostPort(opt.public_host); nil == e {
+		if port, e = strconv.Atoi(p); nil != e {
+			log.Errorf("gen server: invalid port '%s'\n", p);
+			return 1
+		}
+		host = h
+	}
+	url, inbound, e := pkg.Gen_Server(kind, host, port, opt.sni, opt.server_opts);
+	if nil != e {
+		log.Errorf("gen server: %v\n", e);
+		return 1
+	}
+	if e = opt.Init_CFG(); nil != e {
+		log.Errorf("broken or invalid template - %v\n", e);
+		return 1
+	}
+	opt.v2.Set_Inbound(inbound);
+	if e = opt.MK_josn_output(url); nil != e {
+		log.Errorf("gen server: %v\n", e);
+		return 1
+	}
+	if "" == opt.output_dir {
+		fmt.Fprintln(os.Stderr, url);
+	} else {
+		fmt.Println(url);
+	}
+	return 0
+}
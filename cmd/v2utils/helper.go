@@ -3,13 +3,13 @@ package main
 
 import (
 	"os"
-	"bufio"
 	"strings"
 
 	"io/fs"
 	"path/filepath"
 
 	log "github.com/siamak-amo/v2utils/log"
+	pkg "github.com/siamak-amo/v2utils/pkg"
 )
 
 const (
@@ -24,7 +24,7 @@ const (
 var (
 	read_method int = RURL_BUILTIN
 
-	global_scanner *bufio.Scanner
+	global_sub *pkg.SubscriptionReader
 	global_index int
 	global_cfg_list []string
 )
@@ -55,12 +55,8 @@ func (opt *Opt) GetInput() (bool) {
 		}
 		break;
 	case RURL_FILE:
-	read_url_from_file:
-		if global_scanner.Scan() {
-			opt.url = global_scanner.Text()
-			if is_comment(opt.url) {
-				goto read_url_from_file;
-			}
+		if url, ok := global_sub.Next(); ok {
+			opt.url = url
 			return false
 		} else {
 			return true
@@ -78,6 +74,11 @@ func (opt *Opt) GetInput() (bool) {
 		}
 		return false
 	case RCFG_STDIN:
+		// stdin can only be read once
+		if global_index > 0 {
+			return true
+		}
+		global_index += 1
 		opt.cfg = "-"
 		return false;
 	}
@@ -89,6 +90,14 @@ func (opt *Opt) init_read_url() {
 		(1 == len(opt.urls) && opt.urls[0] == "-") {
 		opt.init_read_stdin();
 	} else {
+		// Expand base64 subscriptions given by --url
+		// They are proxy URLs, so http(s) proxies are accepted
+		sub := pkg.NewSubscriptionReader(strings.NewReader(strings.Join(opt.urls, "\n")))
+		sub.Proxy_Links = true
+		opt.urls = nil
+		for link, ok := sub.Next(); ok; link, ok = sub.Next() {
+			opt.urls = append(opt.urls, link)
+		}
 		read_method = RURL_BUILTIN;
 		global_index = 0
 	}
@@ -99,7 +108,8 @@ func (opt *Opt) init_read_file() error {
 	if nil != err {
 		return err
 	}
-	global_scanner = bufio.NewScanner(f)
+	global_sub = pkg.NewSubscriptionReader(f)
+	global_sub.Proxy_Links = true // like --url
 	read_method = RURL_FILE
 	return nil
 }
@@ -108,7 +118,8 @@ func (opt *Opt) init_read_stdin() {
 	if Stdin_is_tty {
 		println ("Reading URLs from STDIN until EOF:")
 	}
-	global_scanner = bufio.NewScanner(os.Stdin)
+	global_sub = pkg.NewSubscriptionReader(os.Stdin)
+	global_sub.Proxy_Links = true // like --url
 	read_method = RURL_FILE
 }
 
@@ -118,6 +129,7 @@ func (opt *Opt) init_read_stdin() {
 // file path (.json, .toml, .yaml), or `-` to read from stdin.
 
 func (opt *Opt) init_read_cfg_stdin() {
+	global_index = 0
 	read_method = RCFG_STDIN
 }
 
@@ -125,7 +137,7 @@ func (opt *Opt) init_read_cfg_stdin() {
 func (opt *Opt) init_read_cfg() {
 	if 0 == len(opt.configs) ||
 		(1 == len(opt.configs) && opt.configs[0] == "-") {
-		read_method = RCFG_STDIN;
+		opt.init_read_cfg_stdin();
 	} else {
 		global_index = 0
 		read_method = RCFG_FILE;
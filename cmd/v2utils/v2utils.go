@@ -4,7 +4,9 @@ package main
 import (
 	"os"
 	"fmt"
+	"path"
 	"time"
+	"slices"
 	"strings"
 	"strconv"
 
@@ -20,6 +22,7 @@ const (
 	CMD_TEST_CFG
 	CMD_RUN_URL
 	CMD_RUN_CFG
+	CMD_GEN
 ) // commands
 
 type Opt struct {
@@ -32,10 +35,33 @@ type Opt struct {
 	rm bool					// remove files if broken or invalid
 	reverse bool            // print broken configs, not functionals
 	verbose bool
+	jobs int                // number of parallel tests
+	unordered bool          // print test results as soon as done
+	batch int               // number of URLs to test per xray instance
+	ipv6 bool               // check IPv6 connectivity
+	subs []string           // subscription URLs
+	sub_proxy string        // URL or config, to download subscriptions through
+	remark_names bool       // use remark of URLs as output filename
+	format string           // output format: json, clash, singbox
+	out_format string       // format of json outputs: json, yaml, toml
+	no_validate bool        // don't check configs by xray before writing
+	server bool             // make server inbounds of URLs (serve command)
+	server_opts pkg.ServerOptions
+	public_host string      // address of client URLs of server configs
+	sni string              // tls and reality server name of gen server
+	fp string               // fingerprint of client URLs of server configs
+	gen_args []string       // gen command: what to generate, and its arg
+	tag_glob string         // only convert outbounds with matching tag
+	balance string          // balancer strategy, to use all the URLs
+	chain bool              // inputs are chains of URLs (URL1,URL2,...)
+	templates []string      // template files and dirs, to be merged
 
 	// Internal
 	cfg string // config or template file path
 	url string
+	used_names map[string]bool // output filenames
+	proxies pkg.ProxyCollector // --format clash, singbox output
+	proxies_file string        // output filename of opt.proxies
 
 	v2 pkg.V2utils
 };
@@ -47,56 +73,166 @@ func print_usage() {
 COMMAND:
       Run:  to execute Xray based on the given configuration
      Test:  to test the current configuration has internet access
+    Serve:  to execute Xray as the server of the given URL
   Convert:  to convert the current configuration to a different format
+      Gen:  to generate credentials: uuid, x25519, shortid [LENGTH],
+            ss-key [METHOD], or a new server: server [KIND]
 
 OPTIONS:
     -u, --url             VPN url (e.g. vless:// trojan://)
+    -L, --chain           chain of VPN urls: 'URL1,URL2,...' to connect
+                          to URL2 through URL1, and so on; with this
+                          option, all the input URLs are chains
     -c, --config          path to config file or folder
-    -t, --template        path to template file
-                          (for Run and Convert commands)
+    -t, --template        path to template file or folder
+                          (for Run and Convert commands); it can be
+                          repeated to merge them, like xray -confdir
+    -S, --set             set template variable: 'NAME=value', used as
+                          ${NAME} or ${NAME:-default} in templates
+                          (environment variables are also used)
     -i, --input           path to input URL file
+    -s, --sub             subscription URL (http or https)
+    -p, --sub-proxy       URL or config file to download subscriptions
+                          through it (e.g. when the provider is blocked)
     -o, --output          path to output folder
-    -v, --verbose         verbose
+    -N, --name-by-remark  with --output, name files by remark of URLs
+                          (the #name part) instead of hash of them
+    -F, --format          output format of URLs: json (default), clash,
+                          singbox; clash and singbox make a single
+                          Clash (Mihomo) proxies or sing-box outbounds
+                          file, clash.yaml or singbox.json in the
+                          output folder
+    -O, --output-format   format of the json outputs: json (default),
+                          yaml, toml; files get the matching extension
+    -B, --balance         make a single config of all the URLs, with a
+                          balancer: leastPing, random, roundRobin and
+                          leastLoad (for Run and Convert commands)
+    -g, --tag             only convert outbounds which their tag matches
+                          the pattern, e.g. 'proxy-*' (for --config)
+    -v, --verbose         verbose (test: also report IP and timing of
+                          the request phases: dial, tls, first byte)
         --no-color        disable log color
+        --no-validate     convert without checking the configs by
+                          xray (invalid configs are skipped by default)
+
+Server options (serve command, convert --server, --public-host):
+        --server          convert URLs to server configs, which accept
+                          the clients of URLs (vless, vmess, trojan, ss),
+                          or server configs (--config) to client URLs
+        --cert, --key     tls certificate and key files
+        --private-key     reality private key (see: xray x25519)
+    -l, --listen          listen address (default 0.0.0.0)
+    -H, --public-host     public address of the server, to convert server
+                          configs (--config) to client URLs, one per client,
+                          or host[:port] of gen server (default port 443)
+        --sni             tls and reality server name of gen server, or
+                          tls server name of client URLs of server configs
+                          without serverName (default: the public host)
+        --fp              tls and reality fingerprint of client URLs of
+                          server configs (default: chrome, by xray-core)
 
 Test command options:
     -r, --reverse         only print broken configs on stdout
     -R, --rm              to remove broken config files
     -T, --timeout         timeout 2s, 20000ms (default 10s)
     -n, --test-count      number of distinct tests before give up
+    -j, --jobs            number of parallel tests (default 1)
+    -U, --unordered       with --jobs, print results as soon as done
+                          instead of the input order
+    -b, --batch           number of URLs to test by a single xray instance
+                          with --jobs, tests of each batch run in parallel
+    -6, --ipv6            also report IPv6 address of VPN (if any)
+        --rounds          number of probes of working configs, after the
+                          first (cold) one, to report latency min/median/p95,
+                          jitter and loss of them (with -v)
 
 Examples:
     # run xray by URL:
     $ v2utils run --url 'vless://id@1.2.3.4:1234'
 
+    # run xray as server of URL, and make the server config:
+    $ v2utils serve -u 'vless://id@1.2.3.4:443?security=tls' \
+                    --cert cert.pem --key key.pem
+    $ v2utils convert --server -u 'vless://...' --private-key KEY
+    $ v2utils convert --config server.json --public-host my.domain
+
+    # make a new reality server config, and its client URL:
+    $ v2utils gen server vless-reality --public-host 1.2.3.4:443 > server.json
+
+    # run xray through two hops (connects to URL2 through URL1):
+    $ v2utils run --chain 'URL1,URL2'
+
+    # run xray by all URLs of a file, with a balancer:
+    $ v2utils run -i urls.txt --balance leastPing
+
     # test json files and remove broken ones
     $ v2utils test --config /path/to/configs/ --rm
 
     # convert URLs to json:
     $ cat url.txt | v2utils convert -o /path/to/configs
 
+    # convert URLs to yaml or toml configs:
+    $ cat url.txt | v2utils convert --output-format yaml -o /path/to/configs
+
+    # with split templates, e.g. inbounds.json and routing.json:
+    $ v2utils run -u URL --template /path/to/templates_dir
+    $ v2utils run -u URL -t inbounds.json -t routing.json
+
+    # with template variables, e.g. "port": ${SOCKS_PORT:-1080}
+    $ v2utils run -u URL --template tmpl.json --set SOCKS_PORT=2080
+
     # convert outbound of json files to URL:
     $ v2utils convert --config /path/to/configs_dir
+    $ v2utils convert --config config.json --tag 'proxy-*'
+
+    # convert URLs to Clash proxies or sing-box outbounds
+    # (Clash and sing-box files are also accepted as input):
+    $ cat url.txt | v2utils convert --format clash > clash.yaml
+    $ cat url.txt | v2utils convert --format singbox > singbox.json
+    $ v2utils convert -i clash.yaml -o /path/to/configs
 `);
 }
 
 func (opt *Opt) GetArgs() {
-	const optstr = "i:u:f:T:t:o:c:n:RrVvhC"
+	const optstr = "i:u:f:T:t:o:c:n:j:b:s:p:F:O:g:B:L:S:e:k:K:l:H:I:P:W:NURrVvhCXE6"
 	lopts := []getopt.Option{
 		{"url",           true,  'u'},
+		{"chain",         true,  'L'},
 		{"config",        true,  'c'},
 		{"template",      true,  't'},
+		{"set",           true,  'S'},
 		{"output",        true,  'o'},
 		{"input",         true,  'i'},
+		{"sub",           true,  's'},
+		{"sub-proxy",     true,  'p'},
+		{"name-by-remark",false, 'N'},
+		{"format",        true,  'F'},
+		{"output-format", true,  'O'},
+		{"tag",           true,  'g'},
+		{"balance",       true,  'B'},
 
 		{"reverse",       false, 'r'},
 		{"rm",            false, 'R'},
 		{"Timeout",       true,  'T'},
 		{"test-count",    true,  'n'},
 		{"tc",            true,  'n'},
+		{"jobs",          true,  'j'},
+		{"unordered",     false, 'U'},
+		{"batch",         true,  'b'},
+		{"ipv6",          false, '6'},
+		{"rounds",        true,  'W'},
 
 		{"help",          false, 'h'},
 		{"no-color",      false, 'C'},
+		{"no-validate",   false, 'X'},
+		{"server",        false, 'E'},
+		{"cert",          true,  'e'},
+		{"key",           true,  'k'},
+		{"private-key",   true,  'K'},
+		{"listen",        true,  'l'},
+		{"public-host",   true,  'H'},
+		{"sni",           true,  'I'},
+		{"fp",            true,  'P'},
 		{"verbose",       false, 'v'},
 		{"version",       false, 'V'},
 	}
@@ -107,6 +243,9 @@ func (opt *Opt) GetArgs() {
 		switch (idx) {
 		case 'u':
 			opt.urls = append (opt.urls, getopt.Optarg); break;
+		case 'L':
+			opt.chain = true
+			opt.urls = append (opt.urls, getopt.Optarg); break;
 		case 'c':
 			for i := getopt.Optind-1;  i < len(argv) &&
 				('-' != argv[i][0] || "-" == argv[i]);  i += 1 {
@@ -114,7 +253,19 @@ func (opt *Opt) GetArgs() {
 			}
 			break;
 		case 't':
+			opt.templates = append (opt.templates, getopt.Optarg);
 			opt.cfg = getopt.Optarg; break;
+		case 'S':
+			name, value, ok := strings.Cut(getopt.Optarg, "=")
+			if !ok || "" == name {
+				log.Errorf("invalid template variable '%s', expected NAME=value\n", getopt.Optarg);
+				break;
+			}
+			if nil == opt.v2.Vars {
+				opt.v2.Vars = make(map[string]string)
+			}
+			opt.v2.Vars[name] = value
+			break;
 		case 'T':
 			var e error
 			if pkg.TestTimeout, e = time.ParseDuration(getopt.Optarg); nil != e {
@@ -123,6 +274,10 @@ func (opt *Opt) GetArgs() {
 			break;
 		case 'i':
 			opt.in_file = getopt.Optarg; break;
+		case 's':
+			opt.subs = append (opt.subs, getopt.Optarg); break;
+		case 'p':
+			opt.sub_proxy = getopt.Optarg; break;
 		case 'o':
 			opt.output_dir = getopt.Optarg; break;
 		case 'R':
@@ -136,8 +291,61 @@ func (opt *Opt) GetArgs() {
 				pkg.TestCount = count
 			}
 			break;
+		case 'W':
+			if count, err := strconv.Atoi(getopt.Optarg); nil == err && count > 0 {
+				pkg.TestRounds = count
+			} else {
+				log.Errorf("invalid number of rounds '%s'\n", getopt.Optarg);
+			}
+			break;
+		case 'j':
+			if count, err := strconv.Atoi(getopt.Optarg); nil == err && count > 0 {
+				opt.jobs = count
+			} else {
+				log.Errorf("invalid number of jobs '%s'\n", getopt.Optarg);
+			}
+			break;
+		case 'U':
+			opt.unordered = true; break;
+		case 'N':
+			opt.remark_names = true; break;
+		case 'F':
+			opt.format = strings.ToLower(getopt.Optarg); break;
+		case 'O':
+			opt.out_format = strings.ToLower(getopt.Optarg); break;
+		case 'g':
+			opt.tag_glob = getopt.Optarg; break;
+		case 'B':
+			opt.balance = getopt.Optarg; break;
+		case '6':
+			opt.ipv6 = true; break;
+		case 'b':
+			if count, err := strconv.Atoi(getopt.Optarg); nil == err && count > 0 {
+				opt.batch = count
+			} else {
+				log.Errorf("invalid batch size '%s'\n", getopt.Optarg);
+			}
+			break;
 		case 'C':
 			log.ColorEnabled = false; break;
+		case 'X':
+			opt.no_validate = true; break;
+		case 'E':
+			opt.server = true; break;
+		case 'e':
+			opt.server_opts.CertFile = getopt.Optarg; break;
+		case 'k':
+			opt.server_opts.KeyFile = getopt.Optarg; break;
+		case 'K':
+			opt.server_opts.RealityPrivateKey = getopt.Optarg; break;
+		case 'l':
+			opt.server_opts.Listen = getopt.Optarg; break;
+		case 'H':
+			opt.public_host = getopt.Optarg; break;
+		case 'I':
+			opt.sni = getopt.Optarg; break;
+		case 'P':
+			opt.fp = getopt.Optarg; break;
 		case 'V':
 			printVersion();
 			os.Exit(0);
@@ -202,6 +410,11 @@ func (opt *Opt) HandleArgs() int {
 			return opt.Set2_test();
 		case "run","Run","RUN", "r","R":
 			return opt.Set2_run();
+		case "serve","Serve","SERVE", "server":
+			opt.server = true
+			return opt.Set2_run();
+		case "gen","Gen","GEN", "generate", "g","G":
+			return opt.Set2_gen(argv[2:]);
 		case "v", "ver", "version":
 			printVersion();
 			os.Exit(0);
@@ -229,6 +442,105 @@ func (opt *Opt) Init() int {
 		log.Errorf("cannot pass --rm and --reverse options together\n");
 		return -1
 	}
+	switch (opt.format) {
+	case "", "json":
+		break;
+	case "clash":
+		opt.proxies = pkg.NewClashProxies()
+		opt.proxies_file = "clash.yaml"
+		break;
+	case "singbox", "sing-box":
+		opt.proxies = pkg.NewSingboxOutbounds()
+		opt.proxies_file = "singbox.json"
+		break;
+	default:
+		log.Errorf("invalid output format '%s'\n", opt.format);
+		return -1
+	}
+	if 1 < len(opt.templates) && slices.Contains(opt.templates, "-") {
+		log.Errorf("cannot merge template from stdin with the others\n");
+		return -1
+	}
+	if _, e := path.Match(opt.tag_glob, ""); nil != e {
+		log.Errorf("invalid tag pattern '%s' - %v\n", opt.tag_glob, e);
+		return -1
+	}
+	if opt.chain {
+		if opt.batch > 1 || "" != opt.balance || nil != opt.proxies {
+			log.Errorf("cannot pass --chain with --batch, --balance or --format\n");
+			return -1
+		}
+	}
+	if opt.jobs > 1 && CMD_TEST_CFG == opt.cmd &&
+		(0 == len(opt.configs) || (1 == len(opt.configs) && "-" == opt.configs[0])) {
+		log.Errorf("cannot pass --jobs with a config from stdin, pass config files\n");
+		return -1
+	}
+	if "" != opt.public_host && CMD_GEN != opt.cmd {
+		if CMD_CONVERT_CFG != opt.cmd {
+			log.Errorf("--public-host is only for converting server configs\n");
+			return -1
+		}
+		opt.server = true
+	}
+	if CMD_GEN == opt.cmd && "server" == opt.gen_args[0] &&
+		1 < len(opt.gen_args) && "trojan-tls" == opt.gen_args[1] &&
+		("" == opt.server_opts.CertFile || "" == opt.server_opts.KeyFile) {
+		log.Errorf("gen server: trojan-tls needs --cert and --key (tls certificate and key files), e.g.\n");
+		log.Errorf("  gen server trojan-tls --public-host my.domain --cert fullchain.pem --key privkey.pem\n");
+		return -1
+	}
+	if opt.server && CMD_CONVERT_CFG != opt.cmd && CMD_GEN != opt.cmd {
+		if CMD_CONVERT_URL != opt.cmd && CMD_RUN_URL != opt.cmd {
+			log.Errorf("--server is only for converting and running URLs, and converting server configs\n");
+			return -1
+		}
+		if opt.chain || "" != opt.balance || nil != opt.proxies {
+			log.Errorf("cannot pass --server with --chain, --balance or --format\n");
+			return -1
+		}
+	}
+	if "" != opt.balance {
+		if CMD_CONVERT_URL != opt.cmd && CMD_RUN_URL != opt.cmd {
+			log.Errorf("--balance is only for converting and running URLs\n");
+			return -1
+		}
+		if nil != opt.proxies {
+			log.Errorf("cannot pass --balance and --format %s together\n", opt.format);
+			return -1
+		}
+		if _, e := pkg.Balancer_Strategy(opt.balance); nil != e {
+			log.Errorf("%v\n", e);
+			return -1
+		}
+	}
+	if "yml" == opt.out_format {
+		opt.out_format = "yaml"
+	}
+	if "" != opt.out_format {
+		if !slices.Contains(pkg.CFG_Out_Formats, opt.out_format) {
+			log.Errorf("invalid output format '%s'\n", opt.out_format);
+			return -1
+		}
+		if nil != opt.proxies {
+			log.Errorf("cannot pass --output-format and --format %s together\n", opt.format);
+			return -1
+		}
+	}
+	if nil != opt.proxies && CMD_TEST_URL == opt.cmd && "" == opt.output_dir {
+		log.Errorf("--format %s needs --output in the test command\n", opt.format);
+		return -1
+	}
+
+	switch (opt.cmd) {
+	case CMD_TEST_URL, CMD_CONVERT_URL, CMD_GEN:
+		if "" != opt.output_dir {
+			if err := os.MkdirAll(opt.output_dir, 0o755); nil != err {
+				log.Errorf ("Could not create dir - %v\n", err);
+				return -1
+			}
+		}
+	}
 
 	switch (opt.cmd) {
 	case CMD_RUN_URL:
@@ -236,9 +548,13 @@ func (opt *Opt) Init() int {
 		break;
 
 	case CMD_TEST_URL, CMD_CONVERT_URL:
-		if "" != opt.output_dir {
-			if err := os.MkdirAll(opt.output_dir, 0o755); nil != err {
-				log.Errorf ("Could not create dir - %v\n", err);
+		if 0 < len(opt.subs) {
+			if e := opt.fetch_subscriptions(); nil != e {
+				log.Errorf ("Could not fetch subscriptions - %v\n", e);
+				return -1
+			}
+			if 0 == len(opt.urls) {
+				log.Errorf ("No URL was found in the subscriptions.\n");
 				return -1
 			}
 		}
@@ -270,21 +586,27 @@ func (opt *Opt) Init() int {
 func (opt Opt) Do() int {
 	switch (opt.cmd) {
 	case CMD_CONVERT_URL:
+		if nil != opt.proxies {
+			if e := opt.proxies.Add_URL(opt.url); nil != e {
+				log.Warnf("Could not convert URL '%s' - %v\n", opt.url, e);
+				return 1;
+			}
+			break;
+		}
 		if !opt.v2.HasTemplate() {
-			if "" != opt.cfg {
-				if e := opt.Apply_template(); nil != e {
-					log.Errorf("broken or invalid template - %v\n", e);
-					return -1;
-				}
-			} else {
-				// No template is provided, using the default one
-				opt.Apply_Default_Template();
+			// The default template, if no template is provided
+			if e := opt.Init_CFG(); nil != e {
+				log.Errorf("broken or invalid template - %v\n", e);
+				return -1;
 			}
 		}
 		if e := opt.Apply_URL(); nil != e {
 			log.Warnf("Could not apply URL '%s' - %v\n", opt.url, e);
 			return 1;
 		}
+		if e := opt.Validate(opt.url); nil != e {
+			return 1;
+		}
 		if e := opt.MK_josn_output(opt.url); nil != e {
 			log.Errorf("IO error: %v\n", e);
 			log.Errorf("Fatal error, exiting.\n");
@@ -317,12 +639,8 @@ func (opt Opt) Do() int {
 		opt.v2.UnsetTemplate()
 		res := opt.Test_URL()
 		// Generating json files if applicable
-		if res && "" != opt.output_dir {
-			if e := opt.MK_josn_output(opt.url); nil != e {
-				log.Errorf("IO error: %v\n", e);
-				log.Errorf("Fatal error, exiting.\n");
-				return -1;
-			}
+		if opt.output_functional_URL(res) < 0 {
+			return -1;
 		}
 		break;
 
@@ -335,13 +653,7 @@ func (opt Opt) Do() int {
 		} else {
 			res = opt.Test_CFG()
 		}
-		if !res && opt.rm { // We are not in reverse mode here
-			if e := os.Remove(opt.cfg); nil != e {
-				log.Errorf("Could not remove %s - %v\n", opt.cfg, e)
-			} else {
-				log.Logf("Broken file %s was removed.\n", opt.cfg)
-			}
-		}
+		opt.rm_broken_CFG(res);
 		break;
 
 	case CMD_RUN_CFG:
@@ -372,11 +684,22 @@ func (opt Opt) Do() int {
 			log.Errorf("Loading config '%s' failed - %v\n", opt.cfg, e)
 			return 1;
 		}
-		res, e := opt.v2.Convert_conf2url();
+		if opt.server {
+			opt.convert_inbounds()
+			break;
+		}
+		if nil != opt.proxies {
+			if e := opt.v2.Convert_conf2proxies(opt.proxies, opt.tag_glob); nil != e {
+				log.Warnf ("Converting '%s' to %s failed - %v\n", opt.cfg, opt.format, e);
+			}
+			break;
+		}
+		res, e := opt.v2.Convert_conf2url(opt.tag_glob);
 		if nil != e {
 			log.Warnf ("Converting '%s' to URL failed - %v\n", opt.cfg, e);
-		} else {
-			fmt.Println(res);
+		}
+		for _, url := range res {
+			fmt.Println(url);
 		}
 		break;
 	}
@@ -384,8 +707,66 @@ func (opt Opt) Do() int {
 	return 0;
 }
 
+func (opt Opt) client_opts() pkg.ClientOptions {
+	return pkg.ClientOptions{Host: opt.public_host, SNI: opt.sni, Fingerprint: opt.fp}
+}
+
+// Prints client URLs of server inbounds of the config
+func (opt Opt) convert_inbounds() {
+	if nil != opt.proxies {
+		if e := opt.v2.Convert_inbounds2proxies(opt.proxies, opt.client_opts()); nil != e {
+			log.Warnf ("Converting inbounds of '%s' to %s failed - %v\n", opt.cfg, opt.format, e);
+		}
+		return
+	}
+	res, e := opt.v2.Convert_inbounds2url(opt.client_opts());
+	if nil != e {
+		log.Warnf ("Converting inbounds of '%s' to URL failed - %v\n", opt.cfg, e);
+	}
+	for _, url := range res {
+		fmt.Println(url);
+	}
+}
+
+// Converts or runs a single config of @urls, with a balancer
+// @return:  0 on success, negative on failures
+func (opt Opt) Do_balance(urls []string) int {
+	if e := opt.Init_CFG(); nil != e {
+		log.Errorf("broken or invalid template - %v\n", e);
+		return -1;
+	}
+	if e := opt.v2.Init_Outbounds_byURLs(urls, opt.balance); nil != e {
+		log.Errorf("Could not apply URLs - %v\n", e);
+		return -1;
+	}
+	switch (opt.cmd) {
+	case CMD_CONVERT_URL:
+		if e := opt.Validate(strings.Join(urls, "\n")); nil != e {
+			return -1;
+		}
+		if e := opt.MK_josn_output(strings.Join(urls, "\n")); nil != e {
+			log.Errorf("IO error: %v\n", e);
+			return -1;
+		}
+		break;
+	case CMD_RUN_URL:
+		if "" == opt.cfg {
+			log.Warnf("No template is provided, using the default template: %s\n",
+				opt.Get_Default_Template());
+		}
+		if e := opt.v2.Exec_Xray(); nil != e {
+			log.Errorf("Exec xray-core failed - %v\n", e)
+			return -1;
+		}
+		break;
+	}
+	return 0;
+}
+
 func init_opt() (opt *Opt) {
-	opt = &Opt{};
+	opt = &Opt{
+		used_names: make(map[string]bool),
+	};
 	opt.GetArgs();
 	if ret := opt.HandleArgs(); ret < 0 {
 		os.Exit (-ret);
@@ -396,8 +777,87 @@ func init_opt() (opt *Opt) {
 	return;
 }
 
+// parallel main loop of the test command (blocking)
+func parallel_loop(opt *Opt) {
+	// Only used by the pool to read inputs
+	in := *opt
+	typ := pkg.TestJob_URL
+	if CMD_TEST_CFG == opt.cmd {
+		typ = pkg.TestJob_CFG
+	} else if opt.chain {
+		typ = pkg.TestJob_Chain
+	}
+
+	pool := pkg.NewTestPool(opt.jobs, opt.get_contester())
+	pool.Ordered = !opt.unordered
+	pool.Run(
+		func() *pkg.TestJob {
+			if EOF := in.GetInput(); true == EOF {
+				return nil
+			}
+			if pkg.TestJob_CFG == typ {
+				return &pkg.TestJob{Type: typ, Input: in.cfg}
+			}
+			return &pkg.TestJob{Type: typ, Input: in.url}
+		},
+		func(job *pkg.TestJob) bool {
+			return opt.Done_TestJob(job) >= 0
+		},
+	);
+}
+
+// batch main loop of the test command (blocking)
+// Only for testing URLs, see pkg.BatchTester
+func batch_loop(opt *Opt) {
+	tester := pkg.NewBatchTester(opt.jobs, opt.get_contester())
+	for EOF := false; !EOF; {
+		jobs := make([]*pkg.TestJob, 0, opt.batch)
+		for len(jobs) < opt.batch {
+			if EOF = opt.GetInput(); true == EOF {
+				break;
+			}
+			jobs = append(jobs, &pkg.TestJob{Type: pkg.TestJob_URL, Input: opt.url})
+		}
+		tester.Test(jobs);
+		for _, job := range jobs {
+			if opt.Done_TestJob(job) < 0 {
+				return;
+			}
+		}
+	}
+}
+
+// main loop of the --balance option (blocking)
+// Makes a single config of all the input URLs
+func balance_loop(opt *Opt) {
+	var urls []string
+	for EOF := opt.GetInput(); !EOF; EOF = opt.GetInput() {
+		urls = append(urls, opt.url)
+	}
+	opt.Do_balance(urls);
+}
+
 // main loop of v2utils program (blocking)
 func main_loop(opt *Opt) {
+	defer opt.MK_proxies_output()
+	if CMD_GEN == opt.cmd {
+		if ret := opt.Do_gen(); 0 != ret {
+			os.Exit(ret);
+		}
+		return;
+	}
+	if "" != opt.balance {
+		balance_loop(opt);
+		return;
+	}
+	if opt.batch > 1 && CMD_TEST_URL == opt.cmd {
+		batch_loop(opt);
+		return;
+	}
+	if opt.jobs > 1 && (CMD_TEST_URL == opt.cmd || CMD_TEST_CFG == opt.cmd) {
+		parallel_loop(opt);
+		return;
+	}
 	for ;; {
 		if EOF := opt.GetInput(); true == EOF {
 			break;
@@ -0,0 +1,453 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package internal
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"encoding/json"
+	"encoding/base64"
+)
+
+// Clash (Mihomo) proxy, the `proxies` list items
+// These are marshalled to yaml by github.com/ghodss/yaml (json tags)
+type ClashProxy struct {
+	Name           string             `json:"name"`
+	Type           string             `json:"type"`
+	Server         string             `json:"server"`
+	Port           clash_int          `json:"port"`
+	UDP            bool               `json:"udp,omitempty"`
+
+	UUID           string             `json:"uuid,omitempty"`     // vless, vmess
+	AlterID        clash_int          `json:"alterId,omitempty"`
+	Cipher         string             `json:"cipher,omitempty"`   // vmess, ss
+	Flow           string             `json:"flow,omitempty"`
+	Encryption     string             `json:"encryption,omitempty"`
+	Username       string             `json:"username,omitempty"` // socks5, http
+	Password       string             `json:"password,omitempty"`
+
+	Network        string             `json:"network,omitempty"`
+	TLS            bool               `json:"tls,omitempty"`
+	ServerName     string             `json:"servername,omitempty"` // vless, vmess
+	SNI            string             `json:"sni,omitempty"`        // the others
+	SkipCertVerify bool               `json:"skip-cert-verify,omitempty"`
+	ALPN           []string           `json:"alpn,omitempty"`
+	Fingerprint    string             `json:"client-fingerprint,omitempty"`
+	RealityOpts    *ClashRealityOpts  `json:"reality-opts,omitempty"`
+	WSOpts         *ClashWSOpts       `json:"ws-opts,omitempty"`
+	GRPCOpts       *ClashGRPCOpts     `json:"grpc-opts,omitempty"`
+	HTTPOpts       *ClashHTTPOpts     `json:"http-opts,omitempty"`
+
+	Ports          string             `json:"ports,omitempty"` // hysteria2
+	Up             string             `json:"up,omitempty"`
+	Down           string             `json:"down,omitempty"`
+	Obfs           string             `json:"obfs,omitempty"`
+	ObfsPassword   string             `json:"obfs-password,omitempty"`
+
+	PrivateKey     string             `json:"private-key,omitempty"` // wireguard
+	PublicKey      string             `json:"public-key,omitempty"`
+	PreSharedKey   string             `json:"pre-shared-key,omitempty"`
+	IP             string             `json:"ip,omitempty"`
+	IPv6           string             `json:"ipv6,omitempty"`
+	MTU            clash_int          `json:"mtu,omitempty"`
+	Reserved       json.RawMessage    `json:"reserved,omitempty"` // [1,2,3] or base64
+}
+type ClashRealityOpts struct {
+	PublicKey      string             `json:"public-key"`
+	ShortID        string             `json:"short-id,omitempty"`
+}
+type ClashWSOpts struct {
+	Path           string             `json:"path,omitempty"`
+	Headers        map[string]string  `json:"headers,omitempty"`
+	HTTPUpgrade    bool               `json:"v2ray-http-upgrade,omitempty"`
+}
+type ClashGRPCOpts struct {
+	ServiceName    string             `json:"grpc-service-name"`
+}
+type ClashHTTPOpts struct {
+	Method         string             `json:"method,omitempty"`
+	Path           []string           `json:"path,omitempty"`
+	Headers        map[string][]string `json:"headers,omitempty"`
+}
+
+type ClashConfig struct {
+	Proxies        []ClashProxy       `json:"proxies"`
+}
+
+// Some generators quote numbers
+type clash_int int
+
+func (i *clash_int) UnmarshalJSON(data []byte) error {
+	var n int
+	if e := json.Unmarshal(data, &n); nil == e {
+		*i = clash_int(n)
+		return nil
+	}
+	var s string
+	if e := json.Unmarshal(data, &s); nil != e {
+		return e
+	}
+	n, e := strconv.Atoi(s)
+	if nil != e {
+		return errors.New("invalid number: " + s)
+	}
+	*i = clash_int(n)
+	return nil
+}
+
+func itoa(i clash_int) string {
+	if 0 == i {
+		return ""
+	}
+	return strconv.Itoa(int(i))
+}
+
+// Hysteria2 bandwidth of Clash, without unit is Mbps
+func clash_bandwidth(bw string) string {
+	if _, e := strconv.ParseFloat(bw, 64); nil == e {
+		return bw + " mbps"
+	}
+	return bw
+}
+
+// Clash wireguard addresses have no prefix length
+func clash_cidr(ip, prefix string) string {
+	if "" == ip || strings.Contains(ip, "/") {
+		return ip
+	}
+	return ip + prefix
+}
+
+// Reserved bytes of wireguard, both formats to `x,y,z`
+func clash_reserved(raw json.RawMessage) (string, error) {
+	if 0 == len(raw) {
+		return "", nil
+	}
+	var arr []int
+	if e := json.Unmarshal(raw, &arr); nil != e {
+		var s string
+		if e = json.Unmarshal(raw, &s); nil != e {
+			return "", e
+		}
+		b, e := base64.StdEncoding.DecodeString(s)
+		if nil != e {
+			return "", errors.New("invalid reserved: " + s)
+		}
+		for _, c := range b {
+			arr = append(arr, int(c))
+		}
+	}
+	res := make([]string, len(arr))
+	for i, n := range arr {
+		res[i] = strconv.Itoa(n)
+	}
+	return strings.Join(res, ","), nil
+}
+
+// Makes URLmap of Clash proxy @src
+func Parse_clash_proxy(src *ClashProxy) (URLmap, error) {
+	res := make(URLmap, 0)
+	res[Remark] = src.Name
+	res[ServerAddress] = src.Server
+	res[ServerPort] = itoa(src.Port)
+
+	tls := src.TLS
+	switch (src.Type) {
+	case "vless":
+		res[Protocol] = "vless"
+		res[Vxess_ID] = src.UUID
+		res[Vless_Flow] = src.Flow
+		res[Vless_ENC] = src.Encryption
+		break;
+	case "vmess":
+		res[Protocol] = "vmess"
+		res[Vxess_ID] = src.UUID
+		res[Vmess_AlterID] = itoa(src.AlterID)
+		res[Vmess_Sec] = src.Cipher
+		break;
+	case "trojan":
+		res[Protocol] = "trojan"
+		res[Trojan_Password] = src.Password
+		tls = true // implied
+		break;
+	case "ss":
+		res[Protocol] = "shadowsocks"
+		res[SS_Method] = src.Cipher
+		res[SS_Password] = src.Password
+		break;
+	case "hysteria2":
+		res[Protocol] = "hysteria2"
+		res[Network] = "hysteria"
+		res[Hy2_Auth] = src.Password
+		res[Hy2_Ports] = src.Ports
+		res[Hy2_Up] = clash_bandwidth(src.Up)
+		res[Hy2_Down] = clash_bandwidth(src.Down)
+		res[Hy2_Obfs] = src.Obfs
+		res[Hy2_ObfsPassword] = src.ObfsPassword
+		tls = true
+		break;
+	case "wireguard":
+		res[Protocol] = "wireguard"
+		res[WG_SecretKey] = src.PrivateKey
+		res[WG_PublicKey] = src.PublicKey
+		res[WG_PreSharedKey] = src.PreSharedKey
+		res[WG_Address] = strings.Trim(
+			clash_cidr(src.IP, "/32") + "," + clash_cidr(src.IPv6, "/128"), ",")
+		res[WG_MTU] = itoa(src.MTU)
+		reserved, e := clash_reserved(src.Reserved)
+		if nil != e {
+			return nil, e
+		}
+		res[WG_Reserved] = reserved
+		return res, nil // no stream settings
+	case "socks5", "http":
+		res[Protocol] = map[string]string{"socks5": "socks", "http": "http"}[src.Type]
+		res[Proxy_User] = src.Username
+		res[Proxy_Pass] = src.Password
+		break;
+	default:
+		return nil, not_implemented ("clash proxy type " + src.Type)
+	}
+
+	if e := parse_clash_network(src, res); nil != e {
+		return nil, e
+	}
+	parse_clash_security(src, tls, res);
+	return res, nil
+}
+
+func parse_clash_network(src *ClashProxy, dst URLmap) error {
+	switch (src.Network) {
+	case "", "tcp":
+		if "" == dst[Network] {
+			dst[Network] = "tcp"
+		}
+		break;
+	case "ws":
+		opts := src.WSOpts
+		if nil == opts {
+			opts = &ClashWSOpts{}
+		}
+		if opts.HTTPUpgrade {
+			dst[Network] = "httpupgrade"
+			dst[HTTPUP_Path] = opts.Path
+			dst[HTTPUP_Host] = opts.Headers["Host"]
+		} else {
+			dst[Network] = "ws"
+			dst[WS_Path] = opts.Path
+			dst[WS_Host] = opts.Headers["Host"]
+		}
+		break;
+	case "grpc":
+		dst[Network] = "grpc"
+		if nil != src.GRPCOpts {
+			dst[GRPC_ServiceName] = src.GRPCOpts.ServiceName
+		}
+		break;
+	case "http":
+		// TCP with HTTP header obfuscation
+		dst[Network] = "tcp"
+		dst[TCP_HeaderType] = "http"
+		if opts := src.HTTPOpts; nil != opts {
+			if 0 != len(opts.Path) {
+				dst[TCP_HTTP_Path] = opts.Path[0]
+			}
+			if host := opts.Headers["Host"]; 0 != len(host) {
+				dst[TCP_HTTP_Host] = host[0]
+			}
+		}
+		break;
+	default:
+		return not_implemented ("clash network " + src.Network)
+	}
+	return nil
+}
+
+func parse_clash_security(src *ClashProxy, tls bool, dst URLmap) {
+	sni := src.ServerName
+	if "" == sni {
+		sni = src.SNI
+	}
+	if nil != src.RealityOpts {
+		dst[Security] = "reality"
+		dst[REALITY_sni] = sni
+		dst[REALITY_fp] = src.Fingerprint
+		dst[REALITY_PublicKey] = src.RealityOpts.PublicKey
+		dst[REALITY_ShortID] = src.RealityOpts.ShortID
+	} else if tls {
+		dst[Security] = "tls"
+		dst[TLS_sni] = sni
+		dst[TLS_fp] = src.Fingerprint
+		dst[TLS_ALPN] = strings.Join(src.ALPN, ",")
+		dst[TLS_AllowInsecure] = strconv.FormatBool(src.SkipCertVerify)
+	} else {
+		dst[Security] = "none"
+	}
+}
+
+// Makes Clash proxy of URLmap @args
+// @args should be normalized by Gen_outbound
+func Gen_clash_proxy(args URLmap) (*ClashProxy, error) {
+	dst := &ClashProxy{
+		Name: args[Remark],
+		Server: args[ServerAddress],
+		UDP: true,
+	}
+	port, e := parse_port (args[ServerPort])
+	if nil != e {
+		return nil, e
+	}
+	dst.Port = clash_int(port)
+	if "" == dst.Name {
+		dst.Name = host_port (dst.Server, port)
+	}
+
+	switch (args[Protocol]) {
+	case "vless":
+		dst.Type = "vless"
+		dst.UUID = args[Vxess_ID]
+		dst.Flow = args[Vless_Flow]
+		if "none" != args[Vless_ENC] {
+			dst.Encryption = args[Vless_ENC]
+		}
+		break;
+	case "vmess":
+		dst.Type = "vmess"
+		dst.UUID = args[Vxess_ID]
+		dst.Cipher = args[Vmess_Sec]
+		alter, _ := strconv.Atoi(args[Vmess_AlterID])
+		dst.AlterID = clash_int(alter)
+		break;
+	case "trojan":
+		// Clash trojan is always over tls
+		if "tls" != args[Security] && "reality" != args[Security] {
+			return nil, not_implemented ("clash trojan without tls")
+		}
+		dst.Type = "trojan"
+		dst.Password = args[Trojan_Password]
+		break;
+	case "ss", "shadowsocks":
+		dst.Type = "ss"
+		dst.Cipher = args[SS_Method]
+		dst.Password = args[SS_Password]
+		return dst, nil // no stream settings
+	case "hysteria2", "hy2":
+		dst.Type = "hysteria2"
+		dst.Password = args[Hy2_Auth]
+		dst.Ports = args[Hy2_Ports]
+		dst.Up = args[Hy2_Up]
+		dst.Down = args[Hy2_Down]
+		dst.Obfs = args[Hy2_Obfs]
+		dst.ObfsPassword = args[Hy2_ObfsPassword]
+		gen_clash_tls (args, dst);
+		dst.TLS = false // implied
+		return dst, nil
+	case "wireguard", "wg":
+		dst.Type = "wireguard"
+		dst.PrivateKey = args[WG_SecretKey]
+		dst.PublicKey = args[WG_PublicKey]
+		dst.PreSharedKey = args[WG_PreSharedKey]
+		for _, addr := range csv2list (args[WG_Address]) {
+			if ip, _, _ := strings.Cut(addr, "/"); strings.Contains(ip, ":") {
+				dst.IPv6 = ip
+			} else {
+				dst.IP = ip
+			}
+		}
+		mtu, _ := strconv.Atoi(args[WG_MTU])
+		dst.MTU = clash_int(mtu)
+		if "" != args[WG_Reserved] {
+			dst.Reserved = json.RawMessage("[" + args[WG_Reserved] + "]")
+		}
+		return dst, nil
+	case "socks":
+		dst.Type = "socks5"
+		dst.Username = args[Proxy_User]
+		dst.Password = args[Proxy_Pass]
+		break;
+	case "http":
+		dst.Type = "http"
+		dst.Username = args[Proxy_User]
+		dst.Password = args[Proxy_Pass]
+		break;
+	default:
+		return nil, not_implemented ("clash proxy type " + args[Protocol])
+	}
+
+	if e := gen_clash_network(args, dst); nil != e {
+		return nil, e
+	}
+	switch (args[Security]) {
+	case "tls":
+		gen_clash_tls (args, dst);
+		break;
+	case "reality":
+		dst.TLS = true
+		dst.Fingerprint = args[REALITY_fp]
+		dst.RealityOpts = &ClashRealityOpts{
+			PublicKey: args[REALITY_PublicKey],
+			ShortID: args[REALITY_ShortID],
+		}
+		clash_set_sni (dst, args[REALITY_sni]);
+		break;
+	}
+	if "trojan" == dst.Type {
+		dst.TLS = false // implied
+	}
+	return dst, nil
+}
+
+// vless and vmess use servername, the others use sni
+func clash_set_sni(dst *ClashProxy, sni string) {
+	if "vless" == dst.Type || "vmess" == dst.Type {
+		dst.ServerName = sni
+	} else {
+		dst.SNI = sni
+	}
+}
+
+func gen_clash_tls(args URLmap, dst *ClashProxy) {
+	dst.TLS = true
+	dst.Fingerprint = args[TLS_fp]
+	dst.SkipCertVerify = "true" == cbool(args[TLS_AllowInsecure])
+	if "" != args[TLS_ALPN] {
+		dst.ALPN = csv2list(args[TLS_ALPN])
+	}
+	clash_set_sni (dst, args[TLS_sni]);
+}
+
+func gen_clash_network(args URLmap, dst *ClashProxy) error {
+	switch (args[Network]) {
+	case "tcp", "":
+		if "http" != args[TCP_HeaderType] {
+			break;
+		}
+		dst.Network = "http"
+		dst.HTTPOpts = &ClashHTTPOpts{Method: "GET", Path: []string{args[TCP_HTTP_Path]}}
+		if "" != args[TCP_HTTP_Host] {
+			dst.HTTPOpts.Headers = map[string][]string{"Host": {args[TCP_HTTP_Host]}}
+		}
+		break;
+	case "ws":
+		dst.Network = "ws"
+		dst.WSOpts = &ClashWSOpts{Path: args[WS_Path]}
+		if "" != args[WS_Host] {
+			dst.WSOpts.Headers = map[string]string{"Host": args[WS_Host]}
+		}
+		break;
+	case "httpupgrade":
+		dst.Network = "ws"
+		dst.WSOpts = &ClashWSOpts{Path: args[HTTPUP_Path], HTTPUpgrade: true}
+		if "" != args[HTTPUP_Host] {
+			dst.WSOpts.Headers = map[string]string{"Host": args[HTTPUP_Host]}
+		}
+		break;
+	case "grpc":
+		dst.Network = "grpc"
+		dst.GRPCOpts = &ClashGRPCOpts{ServiceName: args[GRPC_ServiceName]}
+		break;
+	default:
+		return not_implemented ("clash network " + args[Network])
+	}
+	return nil
+}
@@ -3,7 +3,9 @@ package internal
 
 import (
 	"io"
+	"net"
 	"errors"
+	"strconv"
 	"strings"
 	"net/url"
 	"encoding/json"
@@ -22,11 +24,13 @@ const (
 	Protocol
 	Network
 	Security
+	Remark // the URL #fragment (vmess: ps)
 	// Security: TLS
 	TLS_sni
 	TLS_ALPN // Comma-separated values, no double quote
 	TLS_fp // fingerprint
-	TLS_AllowInsecure
+	TLS_AllowInsecure // not supported by xray-core, see set_sec_tls
+	TLS_PinnedPeerCert // pinnedPeerCertSha256 (pcs), comma-separated
 	// Security: reality
 	REALITY_fp // fingerprint
 	REALITY_sni
@@ -40,7 +44,7 @@ const (
 	TCP_HTTP_Path
 	WS_Path                // web socket
 	WS_Host
-	WS_Headers // Comma-separated values, no double quote
+	WS_Headers // Comma-separated key:value pairs
 	GRPC_Mode               // GRPC
 	GRPC_MultiMode
 	GRPC_ServiceName
@@ -49,10 +53,15 @@ const (
 	XHTTP_Host              // xhttp
 	XHTTP_Path
 	XHTTP_Mode
-	XHTTP_Headers // Comma-separated values, no double quote
+	XHTTP_Headers // Comma-separated key:value pairs
 	HTTPUP_Host              // http upgrade
 	HTTPUP_Path
-	HTTPUP_Headers // Comma-separated values, no double quote
+	HTTPUP_Headers // Comma-separated key:value pairs
+	Hy2_Ports               // hysteria (port hopping range)
+	Hy2_Up
+	Hy2_Down
+	Hy2_Obfs
+	Hy2_ObfsPassword
 	// Protocol parts
 	Vxess_ID  // vless & vmess  we call them vxess
 	Vless_ENC
@@ -63,6 +72,15 @@ const (
 	SS_Password
 	SS_Method
 	Trojan_Password
+	Hy2_Auth
+	WG_SecretKey // wireguard
+	WG_PublicKey
+	WG_PreSharedKey
+	WG_Address // Comma-separated values, no double quote
+	WG_MTU
+	WG_Reserved // Comma-separated values, no double quote
+	Proxy_User // socks & http
+	Proxy_Pass
 )
 
 func unmarshal_H (dst interface{}, input string) (error) {
@@ -94,19 +112,29 @@ func not_implemented (feature string) error {
     return errors.New(feature + " not implemented")
 }
 
-// converts: `x,y,z` -> `"x", "y", "z"`
-func csv2jsonArray (csv string) string {
-	var res string
+// converts: `x,y,z` -> ["x", "y", "z"]
+func csv2list (csv string) []string {
 	if 0 == len(csv) {
-		return "";
+		return []string{}
 	}
-	for _, key := range strings.Split(csv, ",") {
-		res += `"` + key + `",`
-	}
-	if len(res) >= 1 {
-		res = res[:len(res)-1]
+	return strings.Split(csv, ",")
+}
+
+// converts: `k1:v1,k2:v2` -> {"k1": "v1", "k2": "v2"}
+func csv2headers (csv string) (map[string]string, error) {
+	res := make(map[string]string, 0)
+	for _, kv := range csv2list (csv) {
+		k, v, ok := strings.Cut(kv, ":")
+		if !ok {
+			return nil, errors.New("invalid header: " + kv)
+		}
+		res[strings.TrimSpace(k)] = strings.TrimSpace(v)
 	}
-	return res
+	return res, nil
+}
+
+func ptr[T any] (v T) *T {
+	return &v
 }
 
 // Boolean normalizer
@@ -148,6 +176,11 @@ func (m Str2Str) Pop(key string) (string) {
 	return ""
 }
 
+// Makes `address:port`, IPv6 addresses are bracketed
+func host_port(address string, port int) string {
+	return net.JoinHostPort(strings.Trim(address, "[]"), strconv.Itoa(port))
+}
+
 func AddQuery(u url.Values, key,val string) {
 	if "" != key  &&  "" != val {
 		u.Add(key, val)
@@ -0,0 +1,256 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package internal
+
+import (
+	"testing"
+	"encoding/json"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// Values that used to break (or inject into) the generated json
+var hostile_values = []string{
+	`a"b`,
+	`a\b`,
+	`\`,
+	`x", "tag": "injected`,
+	`x"}, "tlsSettings": {"allowInsecure": true}, "x": {"`,
+}
+
+// Reads back a generated value from the outbound
+type hostile_getter func(*conf.OutboundDetourConfig) string
+
+func settings_of[T any](src *conf.OutboundDetourConfig) (v T) {
+	json.Unmarshal (*src.Settings, &v)
+	return
+}
+
+var hostile_protocols = map[string]map[URLMapper]hostile_getter{
+	"vless": {
+		Vxess_ID: func(o *conf.OutboundDetourConfig) string {
+			return settings_of[VLessVnext](o).Vnext[0].Users[0].ID
+		},
+		ServerAddress: func(o *conf.OutboundDetourConfig) string {
+			return settings_of[VLessVnext](o).Vnext[0].Address
+		},
+	},
+	"vmess": {
+		Vxess_ID: func(o *conf.OutboundDetourConfig) string {
+			return settings_of[VmessVnext](o).Vnext[0].Users[0].ID
+		},
+	},
+	"trojan": {
+		Trojan_Password: func(o *conf.OutboundDetourConfig) string {
+			return settings_of[TrojanCFG](o).Servers[0].Password
+		},
+	},
+	"shadowsocks": {
+		SS_Password: func(o *conf.OutboundDetourConfig) string {
+			return settings_of[SSCFG](o).Servers[0].Password
+		},
+	},
+	"socks": {
+		Proxy_User: func(o *conf.OutboundDetourConfig) string {
+			return settings_of[ProxyCFG](o).User
+		},
+		Proxy_Pass: func(o *conf.OutboundDetourConfig) string {
+			return settings_of[ProxyCFG](o).Pass
+		},
+	},
+	"http": {
+		Proxy_Pass: func(o *conf.OutboundDetourConfig) string {
+			return settings_of[ProxyCFG](o).Pass
+		},
+	},
+}
+
+var hostile_networks = map[string]map[URLMapper]hostile_getter{
+	"tcp": {
+		TCP_HTTP_Path: func(o *conf.OutboundDetourConfig) string {
+			v, _ := encode_tcp_header (o.StreamSetting.TCPSettings)
+			path, _ := v.path_host()
+			return path
+		},
+		TCP_HTTP_Host: func(o *conf.OutboundDetourConfig) string {
+			v, _ := encode_tcp_header (o.StreamSetting.TCPSettings)
+			_, host := v.path_host()
+			return host
+		},
+	},
+	"ws": {
+		WS_Path: func(o *conf.OutboundDetourConfig) string { return o.StreamSetting.WSSettings.Path },
+		WS_Host: func(o *conf.OutboundDetourConfig) string { return o.StreamSetting.WSSettings.Host },
+	},
+	"grpc": {
+		GRPC_ServiceName: func(o *conf.OutboundDetourConfig) string {
+			return o.StreamSetting.GRPCSettings.ServiceName
+		},
+	},
+	"kcp": {
+		KCP_SEED: func(o *conf.OutboundDetourConfig) string {
+			masks := o.StreamSetting.FinalMask.Udp
+			return encode_mask_password (masks[len(masks)-1].Settings)
+		},
+	},
+	"xhttp": {
+		XHTTP_Path: func(o *conf.OutboundDetourConfig) string { return o.StreamSetting.SplitHTTPSettings.Path },
+		XHTTP_Host: func(o *conf.OutboundDetourConfig) string { return o.StreamSetting.SplitHTTPSettings.Host },
+	},
+	"httpupgrade": {
+		HTTPUP_Path: func(o *conf.OutboundDetourConfig) string { return o.StreamSetting.HTTPUPGRADESettings.Path },
+		HTTPUP_Host: func(o *conf.OutboundDetourConfig) string { return o.StreamSetting.HTTPUPGRADESettings.Host },
+	},
+}
+
+var hostile_securities = map[string]map[URLMapper]hostile_getter{
+	"none": {},
+	"tls": {
+		TLS_sni: func(o *conf.OutboundDetourConfig) string { return o.StreamSetting.TLSSettings.ServerName },
+	},
+	"reality": {
+		REALITY_sni: func(o *conf.OutboundDetourConfig) string { return o.StreamSetting.REALITYSettings.ServerName },
+		REALITY_SpiderX: func(o *conf.OutboundDetourConfig) string { return o.StreamSetting.REALITYSettings.SpiderX },
+	},
+}
+
+// Generates outbound of @input, checks the tag and settings,
+// and checks values of @getters after a json round trip
+func hostile_check(t *testing.T, name string, input URLmap, getters ...map[URLMapper]hostile_getter) {
+	args := make(URLmap, 0)
+	for k, v := range input {
+		args[k] = v
+	}
+	v, e := Gen_outbound (args)
+	if nil != e {
+		t.Fatalf ("%s: Gen_outbound failed: %v\n", name, e)
+	}
+	j, e := json.Marshal (v[0])
+	if nil != e {
+		t.Fatalf ("%s: json.Marshal failed: %v\n", name, e)
+	}
+	var out conf.OutboundDetourConfig
+	if e = json.Unmarshal (j, &out); nil != e {
+		t.Fatalf ("%s: broken json: %v\n%s\n", name, e, j)
+	}
+
+	if "proxy" != out.Tag {
+		t.Fatalf ("%s: injected tag '%s'\n", name, out.Tag)
+	}
+	if nil != out.StreamSetting && nil != out.StreamSetting.TLSSettings &&
+		out.StreamSetting.TLSSettings.AllowInsecure {
+		t.Fatalf ("%s: injected allowInsecure\n", name)
+	}
+	for _, m := range getters {
+		for key, get := range m {
+			if actual := get(&out); actual != input[key] {
+				t.Fatalf ("%s: key #%d: expected '%s', got '%s'\n",
+					name, key, input[key], actual)
+			}
+		}
+	}
+}
+
+func Test_hostile_values(t *testing.T) {
+	for _, hostile := range hostile_values {
+		for protocol, proto_getters := range hostile_protocols {
+			for network, net_getters := range hostile_networks {
+				for security, sec_getters := range hostile_securities {
+					input := URLmap{
+						Protocol:   protocol,
+						Network:    network,
+						Security:   security,
+						ServerPort: "443",
+						SS_Method:  "aes-256-gcm",
+						TCP_HeaderType: "http",
+						REALITY_PublicKey: "fjvqVT7vMRfRJ_VrKvAaKbM8yOyWKBOgwpPFdz5lKw4",
+					}
+					for _, m := range []map[URLMapper]hostile_getter{
+						proto_getters, net_getters, sec_getters,
+					} {
+						for key := range m {
+							input[key] = hostile
+						}
+					}
+					if "" == input[ServerAddress] {
+						input[ServerAddress] = "1.2.3.4"
+					}
+					name := protocol + "+" + network + "+" + security
+					hostile_check (t, name, input, proto_getters, net_getters, sec_getters)
+				}
+			}
+		}
+	}
+}
+
+func Test_hostile_values_hysteria2_wireguard(t *testing.T) {
+	hy2_getters := map[URLMapper]hostile_getter{
+		Hy2_Auth: func(o *conf.OutboundDetourConfig) string {
+			return o.StreamSetting.HysteriaSettings.Auth
+		},
+		Hy2_ObfsPassword: func(o *conf.OutboundDetourConfig) string {
+			return encode_mask_password (o.StreamSetting.FinalMask.Udp[0].Settings)
+		},
+		TLS_sni: func(o *conf.OutboundDetourConfig) string {
+			return o.StreamSetting.TLSSettings.ServerName
+		},
+	}
+	wg_getters := map[URLMapper]hostile_getter{
+		WG_SecretKey: func(o *conf.OutboundDetourConfig) string {
+			return settings_of[WireGuardCFG](o).SecretKey
+		},
+		WG_PublicKey: func(o *conf.OutboundDetourConfig) string {
+			return settings_of[WireGuardCFG](o).Peers[0].PublicKey
+		},
+	}
+	for _, hostile := range hostile_values {
+		hostile_check (t, "hysteria2", URLmap{
+			Protocol:         "hysteria2",
+			ServerAddress:    "1.2.3.4",
+			Hy2_Auth:         hostile,
+			Hy2_Obfs:         "salamander",
+			Hy2_ObfsPassword: hostile,
+			TLS_sni:          hostile,
+		}, hy2_getters)
+
+		hostile_check (t, "wireguard", URLmap{
+			Protocol:      "wireguard",
+			ServerAddress: "1.2.3.4",
+			WG_SecretKey:  hostile,
+			WG_PublicKey:  hostile,
+		}, wg_getters)
+	}
+}
+
+// xray-core must accept the generated outbounds
+func Test_hostile_values_build(t *testing.T) {
+	for protocol := range hostile_protocols {
+		for network := range hostile_networks {
+			for _, security := range []string{"none", "tls"} {
+				v, e := Gen_outbound (URLmap{
+					Protocol:      protocol,
+					Network:       network,
+					Security:      security,
+					ServerAddress: "1.2.3.4",
+					Vxess_ID:      "a6a4d1c4-6a2e-4a1e-9a4b-62a3b1a6d8f1",
+					SS_Method:     "aes-256-gcm",
+					SS_Password:   `p"a\ss`,
+					Trojan_Password: `p"a\ss`,
+					Proxy_Pass:    `p"a\ss`,
+					TCP_HeaderType: "http",
+					TCP_HTTP_Path: `/"\`,
+					WS_Path:       `/"\`,
+					XHTTP_Path:    `/"\`,
+					HTTPUP_Path:   `/"\`,
+					KCP_SEED:      `s"e\ed`,
+					TLS_sni:       `x.com`,
+				})
+				if nil != e {
+					t.Fatalf ("%s+%s+%s: Gen_outbound failed: %v\n", protocol, network, security, e)
+				}
+				if _, e = v[0].Build(); nil != e {
+					t.Fatalf ("%s+%s+%s: Build failed: %v\n", protocol, network, security, e)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package internal
+
+import (
+	"net/url"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+func Gen_http (args URLmap) (dst *conf.OutboundDetourConfig, e error) {
+    map_normal (args, ServerPort, "80")
+	return gen_proxy_outbound ("http", args);
+}
+
+func Gen_http_URL(src *conf.OutboundDetourConfig) *url.URL {
+	tls := nil != src.StreamSetting && "tls" == src.StreamSetting.Security
+	scheme := "http"
+	if tls {
+		scheme = "https"
+	}
+	u := gen_proxy_URL (scheme, src)
+	if nil == u {
+		return nil
+	}
+	if tls {
+		q := u.Query()
+		set_kv_proxy_tls (src.StreamSetting, q);
+		u.RawQuery = q.Encode()
+	}
+	return u
+}
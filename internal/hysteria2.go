@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package internal
+
+import (
+	"strings"
+	"net/url"
+	"encoding/json"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// Hysteria2 is the `hysteria` protocol (version 2) of xray-core
+func Gen_hysteria2 (args URLmap) (dst *conf.OutboundDetourConfig, e error) {
+    map_normal (args, ServerPort, "443")
+    map_normal (args, Network, "hysteria")
+    map_normal (args, Security, "tls")
+    map_normal (args, TLS_ALPN, "h3")
+	settings := Hysteria2CFG{
+		Version: 2,
+		Address: args[ServerAddress],
+	}
+	if settings.Port, e = parse_port (args[ServerPort]); nil != e {
+		return
+	}
+	if dst, e = gen_outbound_detour ("hysteria", settings); nil != e {
+		return
+	}
+	if dst.StreamSetting, e = Gen_streamSettings (args); nil != e {
+        // log
+		return
+    }
+	return
+}
+
+func Gen_hysteria2_URL(src *conf.OutboundDetourConfig) *url.URL {
+	var hy2 Hysteria2CFG;
+	if e := json.Unmarshal (*src.Settings, &hy2); nil != e {
+		return nil
+	}
+	stream := src.StreamSetting
+	if nil == stream || nil == stream.HysteriaSettings {
+		return nil
+	}
+
+	u := &url.URL{ Scheme: "hysteria2" }
+	u.User = url.User(stream.HysteriaSettings.Auth)
+	u.Host = host_port (hy2.Address, hy2.Port)
+
+	q := u.Query()
+	if nil != stream.TLSSettings {
+		AddQuery (q, "sni", stream.TLSSettings.ServerName)
+		if stream.TLSSettings.AllowInsecure {
+			AddQuery (q, "insecure", "1") // legacy configs
+		}
+		AddQuery (q, "pinSHA256", stream.TLSSettings.PinnedPeerCertSha256)
+		if nil != stream.TLSSettings.ALPN {
+			if alpn := strings.Join(*stream.TLSSettings.ALPN, ","); "h3" != alpn {
+				AddQuery (q, "alpn", alpn)
+			}
+		}
+	}
+	if nil != stream.FinalMask {
+		for _, mask := range stream.FinalMask.Udp {
+			if "salamander" == mask.Type {
+				AddQuery (q, "obfs", "salamander")
+				AddQuery (q, "obfs-password", encode_mask_password(mask.Settings))
+			}
+		}
+	}
+	AddQuery (q, "up", string(stream.HysteriaSettings.Up))
+	AddQuery (q, "down", string(stream.HysteriaSettings.Down))
+	if hop := stream.HysteriaSettings.UdpHop.PortList; 0 != len(hop) {
+		var ports string
+		if e := json.Unmarshal (hop, &ports); nil == e {
+			AddQuery (q, "mport", ports)
+		} else {
+			AddQuery (q, "mport", string(hop))
+		}
+	}
+
+	u.RawQuery = q.Encode()
+	return u
+}
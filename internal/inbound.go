@@ -0,0 +1,339 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+//
+// Server side (inbound) configs of proxy URLs, the inverse
+// of Gen_outbound, so the same URL works for both ends.
+package internal
+
+import (
+	"net"
+	"errors"
+	"strings"
+	"crypto/ecdh"
+	"encoding/json"
+	"encoding/base64"
+
+	"github.com/xtls/xray-core/infra/conf"
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// Server inbound protocols, which have client URLs
+var Server_Protocols = []string{"vless", "vmess", "trojan", "shadowsocks"}
+
+// Server side options, which are not included in URLs
+type ServerOptions struct {
+	Listen string           // listen address (default 0.0.0.0)
+	CertFile string         // tls certificate and key files
+	KeyFile string
+	RealityPrivateKey string
+	RealityTarget string    // reality target (default: sni:443)
+}
+
+// Client side options of server configs
+type ClientOptions struct {
+	Host string             // public address of the server
+	SNI string              // tls server name, if the server has none
+	Fingerprint string      // tls and reality fingerprint (xray default: chrome)
+}
+
+// vless, vmess and trojan clients
+type InboundClient struct {
+	ID       string                 `json:"id,omitempty"`
+	Password string                 `json:"password,omitempty"`
+	Flow     string                 `json:"flow,omitempty"`
+	Security string                 `json:"security,omitempty"`
+	Level    int                    `json:"level"`
+	Email    string                 `json:"email,omitempty"`
+}
+type InboundClientsCFG struct {
+	Clients    []InboundClient      `json:"clients"`
+	Decryption string               `json:"decryption,omitempty"` // vless
+}
+type SSInboundCFG struct {
+	Method   string                 `json:"method"`
+	Password string                 `json:"password"`
+	Network  string                 `json:"network,omitempty"`
+	Clients  []InboundClient        `json:"clients,omitempty"` // multi-user
+}
+
+// Generates server inbound of proxy URL @args
+// The remark of URL is used as email of the client
+func Gen_server_inbound(args URLmap, opts ServerOptions) (*conf.InboundDetourConfig, error) {
+	// Gen_outbound also normalizes @args
+	outbounds, e := Gen_outbound(args);
+	if nil != e {
+		return nil, e
+	}
+	var settings any
+	client := InboundClient{Email: args[Remark]}
+	switch (args[Protocol]) {
+	case "vless":
+		client.ID, client.Flow = args[Vxess_ID], args[Vless_Flow]
+		settings = InboundClientsCFG{
+			Clients: []InboundClient{client},
+			Decryption: args[Vless_ENC],
+		}
+		break;
+	case "vmess":
+		client.ID, client.Security = args[Vxess_ID], args[Vmess_Sec]
+		settings = InboundClientsCFG{Clients: []InboundClient{client}}
+		break;
+	case "trojan":
+		client.Password = args[Trojan_Password]
+		settings = InboundClientsCFG{Clients: []InboundClient{client}}
+		break;
+	case "ss", "shadowsocks":
+		ss := SSInboundCFG{
+			Method: args[SS_Method],
+			Password: args[SS_Password],
+			Network: "tcp,udp",
+		}
+		// 2022 multi-user `server:user` password
+		if strings.HasPrefix(ss.Method, "2022-") {
+			if server, user, ok := strings.Cut(ss.Password, ":"); ok {
+				client.Password = user
+				ss.Password, ss.Clients = server, []InboundClient{client}
+			}
+		}
+		settings = ss
+		break;
+	default:
+		return nil, not_implemented ("server inbound of " + args[Protocol])
+	}
+	raw, e := json.Marshal (settings)
+	if nil != e {
+		return nil, e
+	}
+	port, e := parse_port (args[ServerPort])
+	if nil != e {
+		return nil, e
+	}
+	if "" == opts.Listen {
+		opts.Listen = "0.0.0.0"
+	}
+	dst := &conf.InboundDetourConfig{
+		Protocol: outbounds[0].Protocol,
+		Tag: "inbound",
+		PortList: &conf.PortList{Range: []conf.PortRange{{From: uint32(port), To: uint32(port)}}},
+		ListenOn: &conf.Address{Address: xnet.ParseAddress(opts.Listen)},
+		Settings: (*json.RawMessage)(&raw),
+		StreamSetting: outbounds[0].StreamSetting,
+	}
+	if e = set_server_security (args, opts, dst.StreamSetting); nil != e {
+		return nil, e
+	}
+	return dst, nil
+}
+
+// Replaces client side tls and reality settings of @dst
+// Server tls only has the certificate, alpn and sni of URL
+func set_server_security(args URLmap, opts ServerOptions, dst *conf.StreamConfig) error {
+	dst.TLSSettings, dst.REALITYSettings = nil, nil
+	switch (args[Security]) {
+	case "", "none":
+		if "" != args[TLS_sni] || "" != args[TLS_fp] || "" != args[REALITY_PublicKey] {
+			return errors.New("security is none, but URL has tls fields")
+		}
+		break;
+
+	case "tls":
+		if "" == opts.CertFile || "" == opts.KeyFile {
+			return errors.New("tls needs certificate and key files")
+		}
+		dst.TLSSettings = &conf.TLSConfig{
+			ServerName: args[TLS_sni],
+			ALPN: conf.NewStringList (csv2list (args[TLS_ALPN])),
+			Certs: []*conf.TLSCertConfig{{
+				CertFile: opts.CertFile,
+				KeyFile: opts.KeyFile,
+			}},
+		}
+		break;
+
+	case "reality":
+		if "" == opts.RealityPrivateKey {
+			return errors.New("reality needs the private key")
+		}
+		public, e := Reality_PublicKey(opts.RealityPrivateKey)
+		if nil != e {
+			return e
+		}
+		if "" != args[REALITY_PublicKey] && public != args[REALITY_PublicKey] {
+			return errors.New("reality private key does not match the public key of URL")
+		}
+		if "" == args[REALITY_sni] {
+			return errors.New("reality needs sni")
+		}
+		if "" == opts.RealityTarget {
+			opts.RealityTarget = host_port(args[REALITY_sni], 443)
+		}
+		target, e := json.Marshal (opts.RealityTarget)
+		if nil != e {
+			return e
+		}
+		dst.REALITYSettings = &conf.REALITYConfig{
+			Target: target,
+			ServerNames: []string{args[REALITY_sni]},
+			PrivateKey: opts.RealityPrivateKey,
+			ShortIds: []string{args[REALITY_ShortID]},
+		}
+		break;
+	}
+	return nil
+}
+
+// Returns the reality (x25519) public key of @private
+// Keys are base64 (raw URL encoding), like xray x25519
+func Reality_PublicKey(private string) (string, error) {
+	key, e := base64.RawURLEncoding.DecodeString(private)
+	if nil != e {
+		return "", errors.New("invalid reality private key")
+	}
+	priv, e := ecdh.X25519().NewPrivateKey(key)
+	if nil != e {
+		return "", errors.New("invalid reality private key")
+	}
+	return base64.RawURLEncoding.EncodeToString(priv.PublicKey().Bytes()), nil
+}
+
+// Generates client outbounds of server inbound @src, one per client
+// @opts.Host is the public address of the server
+// The email of clients (or tag of @src) is used as remark
+func Gen_client_outbounds(src *conf.InboundDetourConfig, opts ClientOptions) ([]conf.OutboundDetourConfig, error) {
+	host := opts.Host
+	if nil == src.PortList || 0 == len(src.PortList.Range) {
+		return nil, errors.New("inbound has no port")
+	}
+	if nil == src.Settings {
+		return nil, errors.New("inbound has no settings")
+	}
+	port := int(src.PortList.Range[0].From)
+	stream, e := client_stream(src.StreamSetting, opts)
+	if nil != e {
+		return nil, e
+	}
+
+	var settings []any
+	var remarks []string
+	switch (src.Protocol) {
+	case "vless", "vmess", "trojan":
+		var v InboundClientsCFG
+		if e = json.Unmarshal (*src.Settings, &v); nil != e {
+			return nil, e
+		}
+		for _, c := range v.Clients {
+			settings = append (settings, client_settings(src.Protocol, c, v.Decryption, host, port))
+			remarks = append (remarks, c.Email)
+		}
+		break;
+	case "shadowsocks":
+		var v SSInboundCFG
+		if e = json.Unmarshal (*src.Settings, &v); nil != e {
+			return nil, e
+		}
+		server := ShadojanServer{Address: host, Port: port, Method: v.Method, Password: v.Password}
+		if 0 == len(v.Clients) {
+			settings = append (settings, SSCFG{Servers: []ShadojanServer{server}})
+			remarks = append (remarks, "")
+		}
+		for _, c := range v.Clients {
+			s := server
+			if "" != v.Password {
+				s.Password = v.Password + ":" + c.Password // 2022 multi-user
+			} else {
+				s.Password = c.Password
+			}
+			settings = append (settings, SSCFG{Servers: []ShadojanServer{s}})
+			remarks = append (remarks, c.Email)
+		}
+		break;
+	default:
+		return nil, not_implemented ("client URL of " + src.Protocol)
+	}
+	if 0 == len(settings) {
+		return nil, errors.New("inbound has no client")
+	}
+
+	var res []conf.OutboundDetourConfig
+	for i := range settings {
+		ob, e := gen_outbound_detour (src.Protocol, settings[i])
+		if nil != e {
+			return nil, e
+		}
+		ob.StreamSetting = stream
+		if "" == remarks[i] {
+			remarks[i] = src.Tag
+		}
+		if e = Set_remark (ob, remarks[i]); nil != e {
+			return nil, e
+		}
+		res = append (res, *ob)
+	}
+	return res, nil
+}
+
+func client_settings(protocol string, c InboundClient, decryption, host string, port int) any {
+	switch (protocol) {
+	case "vless":
+		if "" == decryption {
+			decryption = "none"
+		}
+		return VLessVnext{Vnext: []VXessOutboundVnext[VLessAccount]{{
+			Address: host, Port: port,
+			Users: []VLessAccount{{ID: c.ID, Encryption: decryption, Flow: c.Flow, Level: c.Level}},
+		}}}
+	case "vmess":
+		if "" == c.Security {
+			c.Security = "auto"
+		}
+		return VmessVnext{Vnext: []VXessOutboundVnext[VMessAccount]{{
+			Address: host, Port: port,
+			Users: []VMessAccount{{ID: c.ID, Security: c.Security}},
+		}}}
+	default: // trojan
+		return TrojanCFG{Servers: []ShadojanServer{{Address: host, Port: port, Password: c.Password}}}
+	}
+}
+
+// Makes client side stream settings of server @src
+// tls certificates are dropped, and reality public key is derived
+// tls without serverName takes @opts.SNI, or the host if it's a domain,
+// as certificates of IP addresses are rare
+func client_stream(src *conf.StreamConfig, opts ClientOptions) (*conf.StreamConfig, error) {
+	if nil == src {
+		return nil, nil
+	}
+	dst := *src
+	if nil != src.TLSSettings {
+		tls := *src.TLSSettings
+		tls.Certs = nil
+		tls.Fingerprint = opts.Fingerprint
+		if "" == tls.ServerName {
+			tls.ServerName = opts.SNI
+		}
+		if "" == tls.ServerName {
+			if nil != net.ParseIP(opts.Host) {
+				return nil, errors.New("tls inbound has no serverName, and the host is IP (pass sni)")
+			}
+			tls.ServerName = opts.Host
+		}
+		dst.TLSSettings = &tls
+	}
+	if r := src.REALITYSettings; nil != r {
+		public, e := Reality_PublicKey (r.PrivateKey)
+		if nil != e {
+			return nil, e
+		}
+		if 0 == len(r.ServerNames) {
+			return nil, errors.New("reality inbound has no serverNames")
+		}
+		dst.REALITYSettings = &conf.REALITYConfig{
+			ServerName: r.ServerNames[0],
+			Fingerprint: opts.Fingerprint,
+			PublicKey: public,
+		}
+		if 0 != len(r.ShortIds) {
+			dst.REALITYSettings.ShortId = r.ShortIds[0]
+		}
+	}
+	return &dst, nil
+}
@@ -0,0 +1,225 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package internal
+
+import (
+	"testing"
+	"encoding/json"
+)
+
+// Known x25519 key pair (xray x25519)
+const (
+	test_reality_private = "QCmqtA3LvZfbFSyDBO2dbbxLjXy-WRP05DOvbwpGG1Y"
+	test_reality_public = "AZi-2RWaX6ExufBSWgekEeXAmtB-54VufkGScSWKgA4"
+)
+
+func TestReality_PublicKey (t *testing.T) {
+	pub, e := Reality_PublicKey (test_reality_private)
+	if nil != e {
+		t.Fatalf ("Reality_PublicKey failed: %v\n", e)
+	}
+	Assert (t, pub, test_reality_public)
+	if _, e = Reality_PublicKey ("short"); nil == e {
+		t.Fatal ("invalid key was accepted")
+	}
+}
+
+func gen_server_inbound (t *testing.T, url string, opts ServerOptions) *InboundClientsCFG {
+	umap, e := ParseURL (url)
+	if nil != e {
+		t.Fatalf ("ParseURL failed: %v\n", e)
+	}
+	ib, e := Gen_server_inbound (umap, opts)
+	if nil != e {
+		t.Fatalf ("Gen_server_inbound failed: %v\n", e)
+	}
+	if _, e = ib.Build (); nil != e {
+		t.Fatalf ("%s: Build failed: %v\n", url, e)
+	}
+	var v InboundClientsCFG
+	json.Unmarshal (*ib.Settings, &v)
+	return &v
+}
+
+func TestGen_server_inbound (t *testing.T) {
+	v := gen_server_inbound (t, "vless://id-1@1.2.3.4:8443?type=ws&path=/ws#user", ServerOptions{})
+	Assert (t, v.Clients[0].ID, "id-1")
+	Assert (t, v.Clients[0].Email, "user")
+	Assert (t, v.Decryption, "none")
+
+	v = gen_server_inbound (t, "trojan://pass@1.2.3.4:443?type=grpc&serviceName=x", ServerOptions{})
+	Assert (t, v.Clients[0].Password, "pass")
+
+	gen_server_inbound (t, "ss://YWVzLTI1Ni1nY206cGFzcw==@1.2.3.4:8388", ServerOptions{})
+
+	reality := "vless://id@1.2.3.4:443?type=tcp&security=reality&flow=xtls-rprx-vision" +
+		"&sni=x.com&fp=chrome&sid=ab&pbk=" + test_reality_public
+	v = gen_server_inbound (t, reality, ServerOptions{RealityPrivateKey: test_reality_private})
+	Assert (t, v.Clients[0].Flow, "xtls-rprx-vision")
+
+	umap, _ := ParseURL (reality)
+	ib, _ := Gen_server_inbound (umap, ServerOptions{RealityPrivateKey: test_reality_private})
+	r := ib.StreamSetting.REALITYSettings
+	Assert (t, string(r.Target), `"x.com:443"`)
+	Assert (t, r.ServerNames[0], "x.com")
+	Assert (t, r.ShortIds[0], "ab")
+	Assert (t, r.PublicKey, "")
+	Assert (t, ib.ListenOn.String(), "0.0.0.0")
+
+	// Server tls is made from scratch, not the client one
+	tls := "trojan://pass@1.2.3.4:443?security=tls&sni=x.com&fp=chrome&alpn=h2&pcs=" + test_cert_pin
+	umap, _ = ParseURL (tls)
+	ib, e := Gen_server_inbound (umap, ServerOptions{CertFile: "c.pem", KeyFile: "k.pem"})
+	if nil != e {
+		t.Fatalf ("Gen_server_inbound failed: %v\n", e)
+	}
+	ts := ib.StreamSetting.TLSSettings
+	Assert (t, ts.ServerName, "x.com")
+	Assert (t, ts.Fingerprint, "")
+	Assert (t, ts.PinnedPeerCertSha256, "")
+	Assert (t, ts.Certs[0].CertFile, "c.pem")
+	Assert (t, (*ts.ALPN)[0], "h2")
+	if 1 != len(*ts.ALPN) || nil != ib.StreamSetting.REALITYSettings {
+		t.Fatalf ("unexpected server tls %+v\n", *ts)
+	}
+	umap, _ = ParseURL (tls)
+	outbounds, _ := Gen_outbound (umap)
+	if 0 != len(outbounds[0].StreamSetting.TLSSettings.Certs) {
+		t.Fatal ("certificate leaked into the client")
+	}
+
+	// Missing or mismatched server options
+	for url, opts := range map[string]ServerOptions{
+		reality: {},
+		reality + "x": {RealityPrivateKey: test_reality_private},
+		"trojan://pass@1.2.3.4:443?security=tls": {},
+		"socks://1.2.3.4:1080": {},
+	} {
+		umap, _ := ParseURL (url)
+		if _, e := Gen_server_inbound (umap, opts); nil == e {
+			t.Fatalf ("%s: expected error\n", url)
+		}
+	}
+	// tls fields without tls security
+	umap = URLmap{Protocol: "trojan", ServerAddress: "1.2.3.4", ServerPort: "443",
+		Trojan_Password: "pass", Security: "none", TLS_sni: "x.com"}
+	if _, e := Gen_server_inbound (umap, ServerOptions{}); nil == e {
+		t.Fatal ("tls fields of security none were ignored")
+	}
+}
+
+// shadowsocks 2022 multi-user, server and user keys
+func TestGen_server_inbound_SS2022 (t *testing.T) {
+	umap, _ := ParseURL ("ss://MjAyMi1ibGFrZTMtYWVzLTEyOC1nY206TVRJek5EVTJOemc1TURFeU16UTFOZz09OllXSmpaR1ZtWjJocGFtdHNiVzV2Y0E9PQ==@1.2.3.4:8388#user")
+	ib, e := Gen_server_inbound (umap, ServerOptions{})
+	if nil != e {
+		t.Fatalf ("Gen_server_inbound failed: %v\n", e)
+	}
+	if _, e = ib.Build (); nil != e {
+		t.Fatalf ("ss 2022: Build failed: %v\n", e)
+	}
+	var ss SSInboundCFG
+	json.Unmarshal (*ib.Settings, &ss)
+	Assert (t, ss.Password, "MTIzNDU2Nzg5MDEyMzQ1Ng==")
+	Assert (t, ss.Clients[0].Password, "YWJjZGVmZ2hpamtsbW5vcA==")
+	Assert (t, ss.Clients[0].Email, "user")
+}
+
+func TestGen_client_outbounds (t *testing.T) {
+	reality := "vless://id@1.2.3.4:443?type=tcp&security=reality&flow=xtls-rprx-vision" +
+		"&sni=x.com&fp=chrome&sid=ab&pbk=" + test_reality_public + "#user"
+	umap, _ := ParseURL (reality)
+	ib, _ := Gen_server_inbound (umap, ServerOptions{RealityPrivateKey: test_reality_private})
+	clients := json.RawMessage(`{"decryption":"none","clients":[{"id":"id-1","email":"a"},` +
+		`{"id":"id-2","flow":"xtls-rprx-vision"}]}`)
+	ib.Settings = &clients
+	ib.Tag = "in"
+
+	obs, e := Gen_client_outbounds (ib, ClientOptions{Host: "my.host"})
+	if nil != e {
+		t.Fatalf ("Gen_client_outbounds failed: %v\n", e)
+	}
+	if 2 != len(obs) {
+		t.Fatalf ("expected 2 clients, got %d\n", len(obs))
+	}
+	Assert (t, Get_remark (&obs[0]), "a")
+	Assert (t, Get_remark (&obs[1]), "in")
+	r := obs[0].StreamSetting.REALITYSettings
+	Assert (t, r.PublicKey, test_reality_public)
+	Assert (t, r.ServerName, "x.com")
+	Assert (t, r.ShortId, "ab")
+	Assert (t, r.PrivateKey, "")
+	Assert (t, r.Fingerprint, "")
+	// The server config must be kept
+	Assert (t, ib.StreamSetting.REALITYSettings.PrivateKey, test_reality_private)
+
+	u := Gen_URL (&obs[1])
+	Assert (t, u.Host, "my.host:443")
+	Assert (t, u.User.Username(), "id-2")
+	Assert (t, u.Query().Get("flow"), "xtls-rprx-vision")
+	Assert (t, u.Query().Get("pbk"), test_reality_public)
+
+	// shadowsocks 2022 multi-user
+	ss := json.RawMessage(`{"method":"2022-blake3-aes-128-gcm","password":"c2VydmVy",` +
+		`"clients":[{"password":"Y2xpZW50"}]}`)
+	ib.Protocol, ib.StreamSetting, ib.Settings = "shadowsocks", nil, &ss
+	obs, e = Gen_client_outbounds (ib, ClientOptions{Host: "my.host"})
+	if nil != e {
+		t.Fatalf ("Gen_client_outbounds failed: %v\n", e)
+	}
+	var v SSCFG
+	json.Unmarshal (*obs[0].Settings, &v)
+	Assert (t, v.Servers[0].Password, "c2VydmVy:Y2xpZW50")
+
+	// Round trip: server config, URL and back
+	url := Gen_URL (&obs[0])
+	umap, e = ParseURL (url.String())
+	if nil != e {
+		t.Fatalf ("ParseURL of %s failed: %v\n", url, e)
+	}
+	Assert (t, umap[SS_Password], "c2VydmVy:Y2xpZW50")
+	Assert (t, umap[SS_Method], "2022-blake3-aes-128-gcm")
+
+	ib.Protocol = "socks"
+	if _, e = Gen_client_outbounds (ib, ClientOptions{Host: "my.host"}); nil == e {
+		t.Fatal ("socks inbound was accepted")
+	}
+}
+
+func TestGen_client_outbounds_sni (t *testing.T) {
+	umap, _ := ParseURL ("trojan://pass@1.2.3.4:443?security=tls")
+	ib, e := Gen_server_inbound (umap, ServerOptions{CertFile: "c.pem", KeyFile: "k.pem"})
+	if nil != e {
+		t.Fatalf ("Gen_server_inbound failed: %v\n", e)
+	}
+	for _, tc := range []struct {
+		opts ClientOptions
+		sni string
+	}{
+		{ClientOptions{Host: "my.host", Fingerprint: "firefox"}, "my.host"},
+		{ClientOptions{Host: "1.2.3.4", SNI: "x.com", Fingerprint: "firefox"}, "x.com"},
+		{ClientOptions{Host: "1.2.3.4"}, ""}, // error
+	} {
+		obs, e := Gen_client_outbounds (ib, tc.opts)
+		if "" == tc.sni {
+			if nil == e {
+				t.Fatal ("tls of IP host without sni was accepted")
+			}
+			continue
+		}
+		if nil != e {
+			t.Fatalf ("Gen_client_outbounds failed: %v\n", e)
+		}
+		q := Gen_URL (&obs[0]).Query()
+		Assert (t, q.Get("sni"), tc.sni)
+		Assert (t, q.Get("fp"), "firefox")
+	}
+
+	reality := "vless://id@1.2.3.4:443?type=tcp&security=reality&sni=x.com&pbk=" + test_reality_public
+	umap, _ = ParseURL (reality)
+	ib, _ = Gen_server_inbound (umap, ServerOptions{RealityPrivateKey: test_reality_private})
+	obs, e := Gen_client_outbounds (ib, ClientOptions{Host: "1.2.3.4", Fingerprint: "safari"})
+	if nil != e {
+		t.Fatalf ("Gen_client_outbounds failed: %v\n", e)
+	}
+	Assert (t, obs[0].StreamSetting.REALITYSettings.Fingerprint, "safari")
+}
@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package internal
+
+import (
+	"testing"
+	"encoding/json"
+	"encoding/base64"
+)
+
+// IPv6 servers must survive URL -> outbound -> URL
+func Test_ipv6_roundtrip(t *testing.T) {
+	for _, link := range []string{
+		"vless://id@[2001:db8::1]:443?encryption=none&headerType=none&level=0&security=none&type=tcp",
+		"trojan://pass@[2001:db8::1]:443?headerType=none&security=none&type=tcp",
+		"ss://YWVzLTI1Ni1nY206cGFzcw==@[2001:db8::1]:8388",
+		"hysteria2://auth@[2001:db8::1]:443?sni=x.com",
+		"wireguard://key@[2001:db8::1]:51820?mtu=1420&publickey=pub",
+		"socks5://user:pass@[2001:db8::1]:1080",
+		"http://[2001:db8::1]:8080",
+	} {
+		umap, e := ParseURL(link);
+		if nil != e {
+			t.Fatalf ("ParseURL failed: %v\n", e)
+		}
+		Assert (t, umap[ServerAddress], "2001:db8::1");
+
+		v, e := Gen_outbound(umap);
+		if nil != e {
+			t.Fatalf ("Gen_outbound failed: %v\n", e)
+		}
+		if _, e = v[0].Build(); nil != e {
+			t.Fatalf ("Build failed: %v\n", e)
+		}
+		Assert (t, Gen_URL(&v[0]).String(), link);
+	}
+}
+
+func Test_ipv6_vmess(t *testing.T) {
+	for _, add := range []string{"2001:db8::1", "[2001:db8::1]"} {
+		j, _ := json.Marshal(map[string]string{
+			"add": add, "port": "443", "id": "id", "net": "tcp",
+		})
+		umap, e := ParseURL("vmess://" + base64.StdEncoding.EncodeToString(j));
+		if nil != e {
+			t.Fatalf ("ParseURL failed: %v\n", e)
+		}
+		Assert (t, umap[ServerAddress], "2001:db8::1");
+	}
+}
+
+// Bracketed addresses in configs, must not be double bracketed
+func Test_ipv6_host_port(t *testing.T) {
+	Assert (t, host_port("2001:db8::1", 443),   "[2001:db8::1]:443");
+	Assert (t, host_port("[2001:db8::1]", 443), "[2001:db8::1]:443");
+	Assert (t, host_port("1.2.3.4", 443),       "1.2.3.4:443");
+	Assert (t, host_port("vpn.net", 443),       "vpn.net:443");
+}
@@ -0,0 +1,157 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+//
+// Credential generators, like xray uuid and xray x25519
+package internal
+
+import (
+	"fmt"
+	"maps"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"crypto/rand"
+	"crypto/ecdh"
+	"encoding/hex"
+	"encoding/base64"
+
+	"github.com/xtls/xray-core/common/uuid"
+)
+
+// Key length (bytes) of shadowsocks 2022 methods
+var SS2022_KeyLength = map[string]int{
+	"2022-blake3-aes-128-gcm": 16,
+	"2022-blake3-aes-256-gcm": 32,
+	"2022-blake3-chacha20-poly1305": 32,
+}
+
+// Other shadowsocks methods of xray, which accept any password
+var SS_Methods = []string{
+	"aes-128-gcm", "aes-256-gcm", "chacha20-poly1305",
+	"chacha20-ietf-poly1305", "xchacha20-poly1305", "xchacha20-ietf-poly1305",
+}
+
+// Default length (bytes) of reality short IDs (max: 8)
+const ShortID_Length = 8
+
+// Generates a random UUID (vless and vmess ID)
+func Gen_UUID() string {
+	id := uuid.New()
+	return id.String()
+}
+
+// Generates a reality (x25519) key pair, like xray x25519
+func Gen_x25519() (private, public string, e error) {
+	key, e := ecdh.X25519().GenerateKey(rand.Reader)
+	if nil != e {
+		return "", "", e
+	}
+	private = base64.RawURLEncoding.EncodeToString(key.Bytes())
+	public = base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes())
+	return
+}
+
+// Generates a random reality short ID, of @n bytes (hex encoded)
+func Gen_ShortID(n int) (string, error) {
+	if n <= 0 || n > 8 {
+		return "", errors.New("short ID length must be 1 to 8 bytes")
+	}
+	b, e := random_bytes(n)
+	if nil != e {
+		return "", e
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Generates a password of shadowsocks @method
+// 2022 methods need base64 key of the method's length,
+// others of SS_Methods accept any password (16 random bytes in base64)
+func Gen_SS_Password(method string) (string, error) {
+	n, ok := SS2022_KeyLength[method]
+	if !ok {
+		if !slices.Contains(SS_Methods, method) {
+			supported := append(slices.Sorted(maps.Keys(SS2022_KeyLength)), SS_Methods...)
+			return "", fmt.Errorf("unsupported shadowsocks method '%s', supported: %s",
+				method, strings.Join(supported, ", "))
+		}
+		n = 16
+	}
+	b, e := random_bytes(n)
+	if nil != e {
+		return "", e
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// Server kinds of Gen_server_URLmap
+var Server_Kinds = []string{"vless-reality", "vless-ws", "vmess-ws", "trojan-tls", "ss"}
+
+// Default shadowsocks method of Gen_server_URLmap
+const DEF_SS_Method = "2022-blake3-aes-128-gcm"
+
+// Generates URL of a new server of @kind (see Server_Kinds),
+// with random credentials, at the public address @host:@port
+// @sni is the server name of tls and reality (required for reality)
+// For reality, the private key of the URL is also returned
+func Gen_server_URLmap(kind, host string, port int, sni string) (URLmap, string, error) {
+	var private string
+	var e error
+	res := URLmap{
+		ServerAddress: host,
+		ServerPort: strconv.Itoa(port),
+		Network: "tcp",
+		Remark: kind,
+	}
+	switch (kind) {
+	case "vless-reality":
+		if "" == sni {
+			return nil, "", errors.New("reality needs sni")
+		}
+		var public string
+		if private, public, e = Gen_x25519(); nil != e {
+			return nil, "", e
+		}
+		if res[REALITY_ShortID], e = Gen_ShortID(ShortID_Length); nil != e {
+			return nil, "", e
+		}
+		res[Protocol], res[Vxess_ID], res[Vless_Flow] = "vless", Gen_UUID(), "xtls-rprx-vision"
+		res[Security], res[REALITY_sni], res[REALITY_fp] = "reality", sni, "chrome"
+		res[REALITY_PublicKey] = public
+		break;
+	case "vless-ws", "vmess-ws":
+		path, e := Gen_ShortID(4)
+		if nil != e {
+			return nil, "", e
+		}
+		res[Protocol], res[Vxess_ID] = kind[:5], Gen_UUID()
+		res[Network], res[WS_Path] = "ws", "/" + path
+		if "vmess" == res[Protocol] {
+			res[Vmess_Sec] = "auto"
+		}
+		break;
+	case "trojan-tls":
+		if "" == sni {
+			sni = host
+		}
+		res[Protocol], res[Trojan_Password] = "trojan", Gen_UUID()
+		res[Security], res[TLS_sni], res[TLS_fp] = "tls", sni, "chrome"
+		break;
+	case "ss":
+		if res[SS_Password], e = Gen_SS_Password(DEF_SS_Method); nil != e {
+			return nil, "", e
+		}
+		res[Protocol], res[SS_Method] = "ss", DEF_SS_Method
+		break;
+	default:
+		return nil, "", not_implemented ("server kind " + kind)
+	}
+	return res, private, nil
+}
+
+func random_bytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, e := rand.Read(b); nil != e {
+		return nil, e
+	}
+	return b, nil
+}
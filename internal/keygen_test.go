@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package internal
+
+import (
+	"strings"
+	"testing"
+	"encoding/base64"
+)
+
+func TestGen_x25519 (t *testing.T) {
+	private, public, e := Gen_x25519 ()
+	if nil != e {
+		t.Fatalf ("Gen_x25519 failed: %v\n", e)
+	}
+	derived, _ := Reality_PublicKey (private)
+	Assert (t, derived, public)
+}
+
+func TestGen_ShortID (t *testing.T) {
+	sid, e := Gen_ShortID (ShortID_Length)
+	if nil != e || 16 != len(sid) {
+		t.Fatalf ("invalid short ID '%s' - %v\n", sid, e)
+	}
+	if _, e = Gen_ShortID (9); nil == e {
+		t.Fatal ("long short ID was accepted")
+	}
+}
+
+func TestGen_SS_Password (t *testing.T) {
+	for method, n := range SS2022_KeyLength {
+		key, e := Gen_SS_Password (method)
+		if nil != e {
+			t.Fatalf ("Gen_SS_Password failed: %v\n", e)
+		}
+		b, e := base64.StdEncoding.DecodeString (key)
+		if nil != e || n != len(b) {
+			t.Fatalf ("%s: invalid key '%s'\n", method, key)
+		}
+	}
+	if _, e := Gen_SS_Password ("aes-256-gcm"); nil != e {
+		t.Fatalf ("Gen_SS_Password failed: %v\n", e)
+	}
+	for _, method := range []string{"", "aes-256-cfb", "2022-blake3-aes-512-gcm"} {
+		_, e := Gen_SS_Password (method)
+		if nil == e || !strings.Contains (e.Error(), "2022-blake3-aes-128-gcm, ") {
+			t.Fatalf ("%s: unsupported method was accepted: %v\n", method, e)
+		}
+	}
+}
+
+func TestGen_server_URLmap (t *testing.T) {
+	for _, kind := range Server_Kinds {
+		umap, private, e := Gen_server_URLmap (kind, "1.2.3.4", 443, "x.com")
+		if nil != e {
+			t.Fatalf ("%s: Gen_server_URLmap failed: %v\n", kind, e)
+		}
+		opts := ServerOptions{RealityPrivateKey: private, CertFile: "cert.pem", KeyFile: "key.pem"}
+		if _, e = Gen_server_inbound (umap, opts); nil != e {
+			t.Fatalf ("%s: Gen_server_inbound failed: %v\n", kind, e)
+		}
+	}
+	if _, _, e := Gen_server_URLmap ("vless-reality", "1.2.3.4", 443, ""); nil == e {
+		t.Fatal ("reality without sni was accepted")
+	}
+}
@@ -2,9 +2,7 @@
 package internal
 
 import (
-	"fmt"
 	"errors"
-	"strconv"
 	"strings"
 
 	"net/url"
@@ -14,91 +12,179 @@ import (
 )
 
 func set_stream_tcp (args URLmap, dst *conf.StreamConfig) (error) {
+	header := TCPHeaderConfig{Type: args[TCP_HeaderType]}
 	switch (args[TCP_HeaderType]) {
 	case "none", "":
-		return unmarshal_H (&dst.TCPSettings, (`{"header": {"type": "none"}}`));
+		header.Type = "none"
+		break;
 
 	case "http":
-		return unmarshal_H (&dst.TCPSettings,
-			fmt.Sprintf (
-				`{
-                    "header": {
-                        "type": "%s",
-                        "request": {
-                            "version": "1.1", "path": ["%s"], "headers": {"Host": "%s"}
-                        }
-                    }
-                 }`,
-				args[TCP_HeaderType], args[TCP_HTTP_Path], args[TCP_HTTP_Host],
-			),
-		);
+		header.Request = &TCPHeaderRequest{
+			Version: "1.1",
+			Path: conf.StringList{args[TCP_HTTP_Path]},
+			Headers: map[string]*conf.StringList{
+				"Host": conf.NewStringList ([]string{args[TCP_HTTP_Host]}),
+			},
+		}
+		break;
 
 	default:
 		return not_implemented ("not implemented header type: " + args[TCP_HeaderType]);
 	}
+	raw, e := json.Marshal (header)
+	if nil != e {
+		return e
+	}
+	dst.TCPSettings = &conf.TCPConfig{HeaderConfig: raw}
+	return nil
 }
 
 func set_stream_ws (args URLmap, dst *conf.StreamConfig) (error) {
-	args[WS_Headers] = csv2jsonArray (args[WS_Headers]);
-	return unmarshal_H (&dst.WSSettings,
-		fmt.Sprintf (`{"path": "%s", "host": "%s", "headers": [%s]}`,
-			args[WS_Path], args[WS_Host], args[WS_Headers]),
-	);
+	headers, e := csv2headers (args[WS_Headers])
+	if nil != e {
+		return e
+	}
+	dst.WSSettings = &conf.WebSocketConfig{
+		Path: args[WS_Path],
+		Host: args[WS_Host],
+		Headers: headers,
+	}
+	return nil
 }
 
+// Makes finalmask @type mask, with the password @password
+func gen_mask (mask_type, password string) (conf.Mask, error) {
+	mask := conf.Mask{Type: mask_type}
+	if "" != password {
+		raw, e := json.Marshal (map[string]string{"password": password})
+		if nil != e {
+			return mask, e
+		}
+		mask.Settings = (*json.RawMessage)(&raw)
+	}
+	return mask, nil
+}
+
+// mKCP header and seed are finalmask udp masks since xray-core v26
 func set_stream_kcp (args URLmap, dst *conf.StreamConfig) (error) {
-	return unmarshal_H (&dst.KCPSettings,
-		fmt.Sprintf (`{"seed": "%s", "header": {"type": "%s"}}`,
-			args[KCP_SEED], args[KCP_HType]),
-	);
+	var masks []conf.Mask
+	switch (args[KCP_HType]) {
+	case "", "none":
+		break;
+	case "wechat-video":
+		masks = append (masks, conf.Mask{Type: "header-wechat"})
+		break;
+	default:
+		masks = append (masks, conf.Mask{Type: "header-" + args[KCP_HType]})
+		break;
+	}
+	if "" == args[KCP_SEED] {
+		masks = append (masks, conf.Mask{Type: "mkcp-original"})
+	} else {
+		mask, e := gen_mask ("mkcp-aes128gcm", args[KCP_SEED])
+		if nil != e {
+			return e
+		}
+		masks = append (masks, mask)
+	}
+	dst.KCPSettings = &conf.KCPConfig{}
+	dst.FinalMask = &conf.FinalMask{Udp: masks}
+	return nil
+}
+
+func set_stream_hysteria (args URLmap, dst *conf.StreamConfig) (error) {
+	dst.HysteriaSettings = &conf.HysteriaConfig{
+		Version: 2,
+		Auth: args[Hy2_Auth],
+		Up: conf.Bandwidth(args[Hy2_Up]),
+		Down: conf.Bandwidth(args[Hy2_Down]),
+	}
+	if "" != args[Hy2_Ports] {
+		ports, e := json.Marshal (args[Hy2_Ports])
+		if nil != e {
+			return e
+		}
+		dst.HysteriaSettings.UdpHop.PortList = ports
+	}
+	switch (args[Hy2_Obfs]) {
+	case "", "none":
+		return nil
+	case "salamander":
+		mask, e := gen_mask ("salamander", args[Hy2_ObfsPassword])
+		if nil != e {
+			return e
+		}
+		dst.FinalMask = &conf.FinalMask{Udp: []conf.Mask{mask}}
+		return nil
+	default:
+		return not_implemented ("hysteria2 obfs " + args[Hy2_Obfs]);
+	}
 }
 
 func set_stream_grpc (args URLmap, dst *conf.StreamConfig) (error) {
-	return unmarshal_H (&dst.GRPCSettings,
-		fmt.Sprintf (`{"serviceName": "%s", "multiMode": %s, "mode": "%s"}`,
-			args[GRPC_ServiceName], args[GRPC_MultiMode], args[GRPC_Mode]),
-	);
+	dst.GRPCSettings = &conf.GRPCConfig{
+		ServiceName: args[GRPC_ServiceName],
+		MultiMode: "true" == cbool (args[GRPC_MultiMode]) || "multi" == args[GRPC_Mode],
+	}
+	return nil
 }
 
 func set_stream_xhttp (args URLmap, dst *conf.StreamConfig) (error) {
-	args[XHTTP_Headers] = csv2jsonArray (args[XHTTP_Headers]);
-	return unmarshal_H (&dst.SplitHTTPSettings,
-		fmt.Sprintf (`{"host": "%s", "path": "%s", "mode": "%s", "headers": {%s}}`,
-			args[XHTTP_Host], args[XHTTP_Path], args[XHTTP_Mode], args[XHTTP_Headers],
-		),
-	);
+	headers, e := csv2headers (args[XHTTP_Headers])
+	if nil != e {
+		return e
+	}
+	dst.SplitHTTPSettings = &conf.SplitHTTPConfig{
+		Host: args[XHTTP_Host],
+		Path: args[XHTTP_Path],
+		Mode: args[XHTTP_Mode],
+		Headers: headers,
+	}
+	return nil
 }
 
 func set_stream_httpupgrade (args URLmap, dst *conf.StreamConfig) (error) {
-	args[HTTPUP_Headers] = csv2jsonArray (args[HTTPUP_Headers]);
-	return unmarshal_H (&dst.HTTPUPGRADESettings,
-		fmt.Sprintf (`{"host": "%s", "path": "%s", "headers": {%s}}`,
-			args[HTTPUP_Host], args[HTTPUP_Path], args[HTTPUP_Headers],
-		),
-	);
+	headers, e := csv2headers (args[HTTPUP_Headers])
+	if nil != e {
+		return e
+	}
+	dst.HTTPUPGRADESettings = &conf.HttpUpgradeConfig{
+		Host: args[HTTPUP_Host],
+		Path: args[HTTPUP_Path],
+		Headers: headers,
+	}
+	return nil
 }
 
+// Returned by insecure TLS links without a certificate pin
+var Insecure_TLS_Error = errors.New ("allowInsecure is not supported by xray-core, " +
+	"pin the certificate by pcs (pinnedPeerCertSha256)")
+
+// xray-core rejects allowInsecure (since 2026-06-01), so insecure
+// links must pin the certificate, which is used instead
 func set_sec_tls (args URLmap, dst *conf.StreamConfig) (error) {
-	args[TLS_ALPN] = csv2jsonArray (args[TLS_ALPN]);
-	args[TLS_AllowInsecure] = cbool (args[TLS_AllowInsecure]);
-	return unmarshal_H (&dst.TLSSettings,
-		fmt.Sprintf (
-			`{"servername": "%s", "allowInsecure": %s, "alpn": [%s], "fingerprint": "%s"}`,
-			args[TLS_sni], args[TLS_AllowInsecure], args[TLS_ALPN], args[TLS_fp],
-		),
-	);
+	if "true" == cbool (args[TLS_AllowInsecure]) && "" == args[TLS_PinnedPeerCert] {
+		return Insecure_TLS_Error
+	}
+	dst.TLSSettings = &conf.TLSConfig{
+		ServerName: args[TLS_sni],
+		ALPN: conf.NewStringList (csv2list (args[TLS_ALPN])),
+		Fingerprint: args[TLS_fp],
+		PinnedPeerCertSha256: args[TLS_PinnedPeerCert],
+	}
+	return nil
 }
 
 func set_sec_reality (args URLmap, dst *conf.StreamConfig) (error) {
-	args[REALITY_Show] = cbool (args[REALITY_Show]);
-	return unmarshal_H (&dst.REALITYSettings,
-		fmt.Sprintf (
-			`{"serverName": "%s", "fingerprint": "%s", "show": %s,
-              "publicKey": "%s", "shortId": "%s", "spiderX": "%s"}`,
-			args[REALITY_sni], args[REALITY_fp], args[REALITY_Show],
-			args[REALITY_PublicKey], args[REALITY_ShortID], args[REALITY_SpiderX],
-		),
-	);
+	dst.REALITYSettings = &conf.REALITYConfig{
+		ServerName: args[REALITY_sni],
+		Fingerprint: args[REALITY_fp],
+		Show: "true" == cbool (args[REALITY_Show]),
+		PublicKey: args[REALITY_PublicKey],
+		ShortId: args[REALITY_ShortID],
+		SpiderX: args[REALITY_SpiderX],
+	}
+	return nil
 }
 
 func set_stream_settings(args URLmap, dst *conf.StreamConfig) (e error) {
@@ -122,6 +208,9 @@ func set_stream_settings(args URLmap, dst *conf.StreamConfig) (e error) {
 	case "httpupgrade":
 		e = set_stream_httpupgrade (args, dst)
 		break;
+	case "hysteria":
+		e = set_stream_hysteria (args, dst)
+		break;
 	default:
 		return not_implemented ("network " + args[Network])
 	}
@@ -135,7 +224,7 @@ func set_stream_settings(args URLmap, dst *conf.StreamConfig) (e error) {
 		break
 
 	case "tls":
-		map_normal (args, TLS_AllowInsecure, "true")
+		map_normal (args, TLS_AllowInsecure, "false")
 		map_normal (args, TLS_ALPN, "h2,http/1.1")
 		e = set_sec_tls (args, dst)
 		break;
@@ -160,13 +249,9 @@ func Gen_streamSettings(args URLmap) (dst *conf.StreamConfig, e error) {
 	map_normal (args, Network, "tcp")
 	map_normal (args, Security, "none")
 	map_normal (args, TCP_HeaderType, "none")
-	dst = &conf.StreamConfig{}
-	if e = unmarshal_H (dst,
-		fmt.Sprintf (`{"network": "%s", "security": "%s"}`,
-			args[Network], args[Security],
-		)); nil != e {
-		// log
-		return
+	dst = &conf.StreamConfig{
+		Network: (*conf.TransportProtocol)(ptr (args[Network])),
+		Security: args[Security],
 	}
 	if e = set_stream_settings (args, dst); nil != e {
 		// log
@@ -179,26 +264,44 @@ func Gen_streamSettings(args URLmap) (dst *conf.StreamConfig, e error) {
 
 // Only for generating URLs //
 
-// This type is not compatible with xray-core.
 type TCPHeaderConfig struct {
 	Type string						`json:"type"`
-	Request struct {
-		Path string					`json:"path"`
-		Headers map[string]string	`json:"headers"`
-	}
+	Request *TCPHeaderRequest		`json:"request,omitempty"`
+}
+type TCPHeaderRequest struct {
+	Version string					`json:"version,omitempty"`
+	Path conf.StringList			`json:"path"`
+	Headers map[string]*conf.StringList	`json:"headers"`
 }
 type KCPHeaderConfig struct {
 	Type string					    `json:"type"`
 }
 
-func encode_tcp_header(src []byte) (TCPHeaderConfig, error) {
+func encode_tcp_header(src *conf.TCPConfig) (TCPHeaderConfig, error) {
 	v := TCPHeaderConfig{}
-	if e := json.Unmarshal(src, &v); nil != e {
+	if nil == src {
+		return v, errors.New("no tcp settings")
+	}
+	if e := json.Unmarshal(src.HeaderConfig, &v); nil != e {
 		return v,e
 	}
 	return v,nil
 }
 
+// Returns the first path and the host of http header
+func (h TCPHeaderConfig) path_host() (path, host string) {
+	if nil == h.Request {
+		return
+	}
+	if 0 != len(h.Request.Path) {
+		path = h.Request.Path[0]
+	}
+	if v := h.Request.Headers["Host"]; nil != v && 0 != len(*v) {
+		host = (*v)[0]
+	}
+	return
+}
+
 func encode_kcp_header(src []byte) (KCPHeaderConfig, error) {
 	v := KCPHeaderConfig{}
 	if e := json.Unmarshal(src, &v); nil != e {
@@ -207,6 +310,36 @@ func encode_kcp_header(src []byte) (KCPHeaderConfig, error) {
 	return v,nil
 }
 
+func encode_mask_password(src *json.RawMessage) string {
+	v := struct{ Password string `json:"password"` }{}
+	if nil != src {
+		json.Unmarshal(*src, &v)
+	}
+	return v.Password
+}
+
+// Finalmask udp masks of mKCP (see set_stream_kcp)
+func set_kv_kcp_masks(src *conf.FinalMask, dst url.Values) {
+	if nil == src {
+		return
+	}
+	for _, mask := range src.Udp {
+		switch (mask.Type) {
+		case "header-wechat":
+			dst.Set ("headerType", "wechat-video");
+			break;
+		case "mkcp-aes128gcm":
+			AddQuery (dst, "path", encode_mask_password(mask.Settings));
+			break;
+		default:
+			if strings.HasPrefix(mask.Type, "header-") {
+				dst.Set ("headerType", mask.Type[len("header-"):]);
+			}
+			break;
+		}
+	}
+}
+
 type VlessURL_stream_handler  func(src *conf.StreamConfig, dst url.Values)
 type VmessURL_stream_handler  func(src *conf.StreamConfig, dst map[string]string)
 type SSURL_stream_handler     func(src *conf.StreamConfig, dst url.Values)
@@ -227,19 +360,23 @@ func __set_kv_stream_vless_trojan(src *conf.StreamConfig, dst url.Values) {
 			AddQuery (dst, "type", net)
 			switch (net) {
 			case "tcp":
-				if v,e := encode_tcp_header(src.TCPSettings.HeaderConfig); nil == e {
+				if v,e := encode_tcp_header(src.TCPSettings); nil == e {
+					path, host := v.path_host()
 					AddQuery (dst, "headerType", v.Type)
-					AddQuery (dst, "path", v.Request.Path)
-					AddQuery (dst, "host", v.Request.Headers["Host"])
+					AddQuery (dst, "path", path)
+					AddQuery (dst, "host", host)
 				}
 				break;
 			case "mkcp", "kcp":
-				if nil != src.KCPSettings.Seed {
+				if nil != src.KCPSettings && nil != src.KCPSettings.Seed {
 					AddQuery (dst, "path", *src.KCPSettings.Seed);
 				}
-				if v,e := encode_kcp_header(src.KCPSettings.HeaderConfig); nil == e {
-					AddQuery (dst, "headerType", v.Type)
+				if nil != src.KCPSettings {
+					if v,e := encode_kcp_header(src.KCPSettings.HeaderConfig); nil == e {
+						AddQuery (dst, "headerType", v.Type)
+					}
 				}
+				set_kv_kcp_masks (src.FinalMask, dst);
 				break;
 			case "grpc":
 				AddQuery (dst, "serviceName", src.GRPCSettings.ServiceName)
@@ -278,7 +415,10 @@ func __set_kv_stream_vless_trojan(src *conf.StreamConfig, dst url.Values) {
 		AddQuery (dst, "security", sec)
 		switch (sec) {
 		case "tls":
-			AddQuery (dst, "allowInsecure", strconv.FormatBool(src.TLSSettings.Insecure))
+			if src.TLSSettings.AllowInsecure {
+				AddQuery (dst, "allowInsecure", "true") // legacy configs
+			}
+			AddQuery (dst, "pcs", src.TLSSettings.PinnedPeerCertSha256)
 			AddQuery (dst, "sni", src.TLSSettings.ServerName)
 			AddQuery (dst, "fp", src.TLSSettings.Fingerprint)
 			if nil != src.TLSSettings.ALPN {
@@ -290,7 +430,11 @@ func __set_kv_stream_vless_trojan(src *conf.StreamConfig, dst url.Values) {
 			AddQuery (dst, "spx", src.REALITYSettings.SpiderX)
 			AddQuery (dst, "pbk", src.REALITYSettings.PublicKey)
 			AddQuery (dst, "sid", src.REALITYSettings.ShortId)
-			AddQuery (dst, "sni", src.REALITYSettings.ServerNames[0])
+			if "" != src.REALITYSettings.ServerName {
+				AddQuery (dst, "sni", src.REALITYSettings.ServerName)
+			} else if 0 != len(src.REALITYSettings.ServerNames) {
+				AddQuery (dst, "sni", src.REALITYSettings.ServerNames[0])
+			}
 			AddQuery (dst, "mode", src.REALITYSettings.Type)
 			break;
 		}
@@ -304,10 +448,9 @@ func __set_kv_stream_vmess(src *conf.StreamConfig, dst map[string]string) {
 		dst["net"] = net
 		switch (net) {
 		case "tcp":
-			if v,e := encode_tcp_header(src.TCPSettings.HeaderConfig); nil == e {
+			if v,e := encode_tcp_header(src.TCPSettings); nil == e {
 				dst["type"] = v.Type
-				dst["path"] = v.Request.Path
-				dst["host"] = v.Request.Headers["Host"]
+				dst["path"], dst["host"] = v.path_host()
 			}
 			break;
 		case "grpc":
@@ -327,7 +470,12 @@ func __set_kv_stream_vmess(src *conf.StreamConfig, dst map[string]string) {
 		if sec := src.Security; "tls" == sec {
 			dst["tls"] = "tls";
 			dst["fp"] = src.TLSSettings.Fingerprint
-			dst["allowInsecure"] = strconv.FormatBool(src.TLSSettings.Insecure)
+			if src.TLSSettings.AllowInsecure {
+				dst["allowInsecure"] = "true" // legacy configs
+			}
+			if "" != src.TLSSettings.PinnedPeerCertSha256 {
+				dst["pcs"] = src.TLSSettings.PinnedPeerCertSha256
+			}
 			dst["sni"] = src.TLSSettings.ServerName
 			if nil != src.TLSSettings.ALPN {
 				dst["alpn"] = strings.Join(*src.TLSSettings.ALPN, ",")
@@ -2,6 +2,7 @@
 package internal
 
 import (
+	"errors"
 	"testing"
 )
 
@@ -76,7 +77,8 @@ func Test_Gen_StreamSettings_2 (t *testing.T) {
 	tc.Assert (tc.Output.Network,    tc.Input[Network])
 	tc.Assert (tc.Output.Security,   tc.Input[Security])
 	tc.Assert (tls.ServerName,       tc.Input[TLS_sni])
-	tc.Assert (tls.Insecure,         "true")
+	// xray-core no longer accepts allowInsecure
+	tc.Assert (tls.Insecure,         "false")
 }
 
 // Reality test
@@ -112,3 +114,90 @@ func Test_Gen_StreamSettings_3 (t *testing.T) {
 	tc.Assert (reality.SpiderX,             tc.Input[REALITY_SpiderX]);
 	tc.Assert (reality.PublicKey,           tc.Input[REALITY_PublicKey]);
 }
+
+// WS headers
+func Test_Gen_StreamSettings_4 (t *testing.T) {
+	tc := TestCase[StreamConfig] {T: t,
+		Input: map[URLMapper]string {
+			Network:       "ws",
+			WS_Path:       "/ws",
+			WS_Headers:    "User-Agent:x/1.0, X-Y: z",
+		},
+		Output: StreamConfig{},
+	}
+	v, e := Gen_streamSettings (tc.Input)
+	if nil != e {
+		t.Fatalf ("Gen_streamSettings failed: %v\n", e)
+		return
+	}
+
+	tc.Do(v);
+	ws := tc.Output.WSSettings
+	if nil == ws {
+		t.Fatalf ("WSSettings is uninitialized")
+	}
+	tc.Assert (ws.Path,                  tc.Input[WS_Path])
+	tc.Assert (ws.Headers["User-Agent"], "x/1.0")
+	tc.Assert (ws.Headers["X-Y"],        "z")
+
+	tc.Input[WS_Headers] = "invalid"
+	if _, e = Gen_streamSettings (tc.Input); nil == e {
+		t.Fatalf ("expected invalid header error\n")
+	}
+}
+
+// sha256 of a certificate (hex), for pinnedPeerCertSha256
+const test_cert_pin = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// xray-core rejects allowInsecure, so insecure links must
+// pin the certificate, which is used instead
+func Test_Gen_StreamSettings_Insecure (t *testing.T) {
+	for url, pin := range map[string]string{
+		"vless://id@1.2.3.4:443?security=tls&sni=x.com&allowInsecure=1": "",
+		"trojan://pass@1.2.3.4:443?security=tls&sni=x.com&allowInsecure=1&pcs=" + test_cert_pin: test_cert_pin,
+		"hysteria2://auth@1.2.3.4:443?sni=x.com&insecure=1": "",
+		"hysteria2://auth@1.2.3.4:443?sni=x.com&insecure=1&pinSHA256=" + test_cert_pin: test_cert_pin,
+	} {
+		umap, e := ParseURL (url)
+		if nil != e {
+			t.Fatalf ("%s: ParseURL failed: %v\n", url, e)
+		}
+		v, e := Gen_outbound (umap)
+		if "" == pin {
+			if !errors.Is (e, Insecure_TLS_Error) {
+				t.Fatalf ("%s: expected insecure error, got: %v\n", url, e)
+			}
+			continue
+		}
+		if nil != e {
+			t.Fatalf ("%s: Gen_outbound failed: %v\n", url, e)
+		}
+		if _, e = v[0].Build (); nil != e {
+			t.Fatalf ("%s: Build failed: %v\n", url, e)
+		}
+		tls := v[0].StreamSetting.TLSSettings
+		if tls.AllowInsecure {
+			t.Fatalf ("%s: allowInsecure was not replaced\n", url)
+		}
+		Assert (t, tls.PinnedPeerCertSha256, pin)
+
+		u := Gen_URL (&v[0])
+		if q := u.Query(); "" != q.Get("allowInsecure") || "" != q.Get("insecure") {
+			t.Fatalf ("%s: generated URL is insecure: %s\n", url, u)
+		}
+	}
+
+	// Clash skip-cert-verify
+	umap, e := Parse_clash_proxy (&ClashProxy{Type: "trojan", Server: "1.2.3.4", Port: 443,
+		Password: "pass", TLS: true, SNI: "x.com", SkipCertVerify: true})
+	if nil != e {
+		t.Fatalf ("Parse_clash_proxy failed: %v\n", e)
+	}
+	if _, e = Gen_outbound (umap); !errors.Is (e, Insecure_TLS_Error) {
+		t.Fatalf ("clash skip-cert-verify: expected insecure error, got: %v\n", e)
+	}
+	// Clash supports it, so it's kept for Clash outputs
+	if p, _ := Gen_clash_proxy (umap); !p.SkipCertVerify {
+		t.Fatal ("skip-cert-verify of clash was lost")
+	}
+}
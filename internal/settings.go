@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+//
+// Outbound settings types, they are used to generate
+// the settings of outbounds (encoding/json), and to read
+// them back when generating URLs.
+package internal
+
+import (
+	"errors"
+	"strconv"
+	"encoding/json"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// vnext for vless/vmess, account can be any of xxxAccount types
+type VXessOutboundConfig[T any] struct {
+	Vnext []T						`json:"vnext"`
+}
+// for shadowsocks and trojan
+type ServerConfig[T any] struct {
+	Servers  []T                    `json:"servers"`
+}
+
+type VXessOutboundVnext[ACCOUNT any] struct {
+	Address string					`json:"address"`
+	Port    int						`json:"port"`
+	Users   []ACCOUNT				`json:"users"`
+}
+
+type ShadojanServer struct { // shadowsocks and trojan!
+	Address  string	                `json:"address"`
+	Port     int		            `json:"port"`
+	Method   string	                `json:"method,omitempty"`
+	Password string                 `json:"password"`
+}
+
+type VMessAccount struct {
+	ID          string				`json:"id"`
+	AlterIds    int					`json:"alterId"`
+	Security    string				`json:"security"`
+}
+type VLessAccount struct {
+	ID          string				`json:"id"`
+	Encryption  string				`json:"encryption"`
+	Flow        string              `json:"flow"`
+	Level       int					`json:"level"`
+}
+
+type VLessVnext VXessOutboundConfig[VXessOutboundVnext[VLessAccount]]
+
+type VmessVnext VXessOutboundConfig[VXessOutboundVnext[VMessAccount]]
+
+type Hysteria2CFG struct {
+	Version  int                    `json:"version"`
+	Address  string                 `json:"address"`
+	Port     int                    `json:"port"`
+}
+
+type WireGuardPeer struct {
+	PublicKey    string             `json:"publicKey"`
+	PreSharedKey string             `json:"preSharedKey,omitempty"`
+	Endpoint     string             `json:"endpoint"`
+}
+type WireGuardCFG struct {
+	SecretKey string                `json:"secretKey"`
+	Address   []string              `json:"address,omitempty"`
+	Peers     []WireGuardPeer       `json:"peers"`
+	MTU       int                   `json:"mtu"`
+	Reserved  []int                 `json:"reserved,omitempty"`
+}
+
+// socks and http, both formats: flat and servers
+type ProxyAccount struct {
+	User     string                 `json:"user,omitempty"`
+	Pass     string                 `json:"pass,omitempty"`
+}
+type ProxyServer struct {
+	Address  string                 `json:"address"`
+	Port     int                    `json:"port"`
+	Users    []ProxyAccount         `json:"users"`
+}
+type ProxyCFG struct {
+	Address  string                 `json:"address,omitempty"`
+	Port     int                    `json:"port,omitempty"`
+	ProxyAccount
+	Servers  []ProxyServer          `json:"servers,omitempty"`
+}
+
+type SSCFG ServerConfig[ShadojanServer]
+type TrojanCFG ServerConfig[ShadojanServer]
+
+
+// Makes an outbound with the tag `proxy`
+func gen_outbound_detour (protocol string, settings any) (*conf.OutboundDetourConfig, error) {
+	raw, e := json.Marshal (settings)
+	if nil != e {
+		return nil, e
+	}
+	return &conf.OutboundDetourConfig{
+		Protocol: protocol,
+		Tag: "proxy",
+		Settings: (*json.RawMessage)(&raw),
+	}, nil
+}
+
+func parse_port (port string) (int, error) {
+	p, e := strconv.Atoi (port)
+	if nil != e || p <= 0 || p > 65535 {
+		return 0, errors.New ("invalid port: " + port)
+	}
+	return p, nil
+}
+
+func parse_int (name, val string) (int, error) {
+	i, e := strconv.Atoi (val)
+	if nil != e {
+		return 0, errors.New ("invalid " + name + ": " + val)
+	}
+	return i, nil
+}
@@ -0,0 +1,415 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package internal
+
+import (
+	"strconv"
+	"strings"
+)
+
+// sing-box outbound, the `outbounds` list items
+type SingboxOutbound struct {
+	Type           string             `json:"type"`
+	Tag            string             `json:"tag"`
+	Server         string             `json:"server"`
+	ServerPort     int                `json:"server_port"`
+
+	UUID           string             `json:"uuid,omitempty"`     // vless, vmess
+	Flow           string             `json:"flow,omitempty"`
+	Security       string             `json:"security,omitempty"` // vmess
+	AlterID        int                `json:"alter_id,omitempty"`
+	Method         string             `json:"method,omitempty"`   // shadowsocks
+	Version        string             `json:"version,omitempty"`  // socks
+	Username       string             `json:"username,omitempty"` // socks, http
+	Password       string             `json:"password,omitempty"`
+
+	ServerPorts    []string           `json:"server_ports,omitempty"` // hysteria2
+	UpMbps         int                `json:"up_mbps,omitempty"`
+	DownMbps       int                `json:"down_mbps,omitempty"`
+	Obfs           *SingboxObfs       `json:"obfs,omitempty"`
+
+	TLS            *SingboxTLS        `json:"tls,omitempty"`
+	Transport      *SingboxTransport  `json:"transport,omitempty"`
+}
+type SingboxObfs struct {
+	Type           string             `json:"type"`
+	Password       string             `json:"password,omitempty"`
+}
+type SingboxTLS struct {
+	Enabled        bool               `json:"enabled"`
+	ServerName     string             `json:"server_name,omitempty"`
+	Insecure       bool               `json:"insecure,omitempty"`
+	ALPN           []string           `json:"alpn,omitempty"`
+	UTLS           *SingboxUTLS       `json:"utls,omitempty"`
+	Reality        *SingboxReality    `json:"reality,omitempty"`
+}
+type SingboxUTLS struct {
+	Enabled        bool               `json:"enabled"`
+	Fingerprint    string             `json:"fingerprint,omitempty"`
+}
+type SingboxReality struct {
+	Enabled        bool               `json:"enabled"`
+	PublicKey      string             `json:"public_key"`
+	ShortID        string             `json:"short_id,omitempty"`
+}
+type SingboxTransport struct {
+	Type           string             `json:"type"`
+	Path           string             `json:"path,omitempty"`        // ws, httpupgrade
+	Host           string             `json:"host,omitempty"`        // httpupgrade
+	Headers        map[string]string  `json:"headers,omitempty"`     // ws
+	ServiceName    string             `json:"service_name,omitempty"` // grpc
+}
+
+// sing-box wireguard endpoint, the `endpoints` list items
+type SingboxEndpoint struct {
+	Type           string             `json:"type"`
+	Tag            string             `json:"tag"`
+	Address        []string           `json:"address,omitempty"`
+	PrivateKey     string             `json:"private_key"`
+	MTU            int                `json:"mtu,omitempty"`
+	Peers          []SingboxPeer      `json:"peers"`
+}
+type SingboxPeer struct {
+	Address        string             `json:"address"`
+	Port           int                `json:"port"`
+	PublicKey      string             `json:"public_key"`
+	PreSharedKey   string             `json:"pre_shared_key,omitempty"`
+	AllowedIPs     []string           `json:"allowed_ips,omitempty"`
+	Reserved       []int              `json:"reserved,omitempty"`
+}
+
+type SingboxConfig struct {
+	Outbounds      []SingboxOutbound  `json:"outbounds"`
+	Endpoints      []SingboxEndpoint  `json:"endpoints,omitempty"`
+}
+
+// xray bandwidth `10 mbps` -> 10
+func singbox_mbps(bw string) int {
+	f := strings.Fields(strings.ToLower(bw))
+	if 0 == len(f) {
+		return 0
+	}
+	n, e := strconv.ParseFloat(f[0], 64)
+	if nil != e {
+		return 0
+	}
+	if 2 == len(f) {
+		switch (f[1]) {
+		case "gbps", "g":
+			n *= 1000
+		case "kbps", "k":
+			n /= 1000
+		}
+	}
+	return int(n)
+}
+
+func singbox_bandwidth(mbps int) string {
+	if 0 == mbps {
+		return ""
+	}
+	return strconv.Itoa(mbps) + " mbps"
+}
+
+// Makes URLmap of sing-box outbound @src
+func Parse_singbox_outbound(src *SingboxOutbound) (URLmap, error) {
+	res := make(URLmap, 0)
+	res[Remark] = src.Tag
+	res[ServerAddress] = src.Server
+	res[ServerPort] = strconv.Itoa(src.ServerPort)
+
+	switch (src.Type) {
+	case "vless":
+		res[Protocol] = "vless"
+		res[Vxess_ID] = src.UUID
+		res[Vless_Flow] = src.Flow
+		break;
+	case "vmess":
+		res[Protocol] = "vmess"
+		res[Vxess_ID] = src.UUID
+		res[Vmess_Sec] = src.Security
+		res[Vmess_AlterID] = strconv.Itoa(src.AlterID)
+		break;
+	case "trojan":
+		res[Protocol] = "trojan"
+		res[Trojan_Password] = src.Password
+		break;
+	case "shadowsocks":
+		res[Protocol] = "shadowsocks"
+		res[SS_Method] = src.Method
+		res[SS_Password] = src.Password
+		break;
+	case "hysteria2":
+		res[Protocol] = "hysteria2"
+		res[Network] = "hysteria"
+		res[Hy2_Auth] = src.Password
+		res[Hy2_Ports] = strings.ReplaceAll(strings.Join(src.ServerPorts, ","), ":", "-")
+		res[Hy2_Up] = singbox_bandwidth(src.UpMbps)
+		res[Hy2_Down] = singbox_bandwidth(src.DownMbps)
+		if nil != src.Obfs {
+			res[Hy2_Obfs] = src.Obfs.Type
+			res[Hy2_ObfsPassword] = src.Obfs.Password
+		}
+		break;
+	case "socks", "http":
+		res[Protocol] = src.Type
+		res[Proxy_User] = src.Username
+		res[Proxy_Pass] = src.Password
+		break;
+	default:
+		return nil, not_implemented ("sing-box outbound type " + src.Type)
+	}
+
+	if e := parse_singbox_transport(src.Transport, res); nil != e {
+		return nil, e
+	}
+	parse_singbox_tls(src.TLS, res);
+	return res, nil
+}
+
+func parse_singbox_transport(src *SingboxTransport, dst URLmap) error {
+	if nil == src {
+		if "" == dst[Network] {
+			dst[Network] = "tcp"
+		}
+		return nil
+	}
+	switch (src.Type) {
+	case "ws":
+		dst[Network] = "ws"
+		dst[WS_Path] = src.Path
+		dst[WS_Host] = src.Headers["Host"]
+		break;
+	case "httpupgrade":
+		dst[Network] = "httpupgrade"
+		dst[HTTPUP_Path] = src.Path
+		dst[HTTPUP_Host] = src.Host
+		break;
+	case "grpc":
+		dst[Network] = "grpc"
+		dst[GRPC_ServiceName] = src.ServiceName
+		break;
+	default:
+		return not_implemented ("sing-box transport " + src.Type)
+	}
+	return nil
+}
+
+func parse_singbox_tls(src *SingboxTLS, dst URLmap) {
+	if nil == src || !src.Enabled {
+		if "" == dst[Security] {
+			dst[Security] = "none"
+		}
+		return
+	}
+	fp := ""
+	if nil != src.UTLS && src.UTLS.Enabled {
+		fp = src.UTLS.Fingerprint
+	}
+	if nil != src.Reality && src.Reality.Enabled {
+		dst[Security] = "reality"
+		dst[REALITY_sni] = src.ServerName
+		dst[REALITY_fp] = fp
+		dst[REALITY_PublicKey] = src.Reality.PublicKey
+		dst[REALITY_ShortID] = src.Reality.ShortID
+	} else {
+		dst[Security] = "tls"
+		dst[TLS_sni] = src.ServerName
+		dst[TLS_fp] = fp
+		dst[TLS_ALPN] = strings.Join(src.ALPN, ",")
+		dst[TLS_AllowInsecure] = strconv.FormatBool(src.Insecure)
+	}
+}
+
+// Makes URLmap of sing-box wireguard endpoint @src
+func Parse_singbox_endpoint(src *SingboxEndpoint) (URLmap, error) {
+	if "wireguard" != src.Type {
+		return nil, not_implemented ("sing-box endpoint type " + src.Type)
+	}
+	if 0 == len(src.Peers) {
+		return nil, not_implemented ("wireguard endpoint without peer")
+	}
+	peer := src.Peers[0]
+	reserved := make([]string, len(peer.Reserved))
+	for i, n := range peer.Reserved {
+		reserved[i] = strconv.Itoa(n)
+	}
+	res := URLmap{
+		Protocol: "wireguard",
+		Remark: src.Tag,
+		ServerAddress: peer.Address,
+		ServerPort: strconv.Itoa(peer.Port),
+		WG_SecretKey: src.PrivateKey,
+		WG_PublicKey: peer.PublicKey,
+		WG_PreSharedKey: peer.PreSharedKey,
+		WG_Address: strings.Join(src.Address, ","),
+		WG_Reserved: strings.Join(reserved, ","),
+	}
+	if 0 != src.MTU {
+		res[WG_MTU] = strconv.Itoa(src.MTU)
+	}
+	return res, nil
+}
+
+// Makes sing-box outbound of URLmap @args
+// @args should be normalized by Gen_outbound
+// Wireguard is not an outbound in sing-box, see Gen_singbox_endpoint
+func Gen_singbox_outbound(args URLmap) (*SingboxOutbound, error) {
+	port, e := parse_port (args[ServerPort])
+	if nil != e {
+		return nil, e
+	}
+	dst := &SingboxOutbound{
+		Tag: args[Remark],
+		Server: args[ServerAddress],
+		ServerPort: port,
+	}
+	if "" == dst.Tag {
+		dst.Tag = host_port (dst.Server, port)
+	}
+
+	switch (args[Protocol]) {
+	case "vless":
+		dst.Type = "vless"
+		dst.UUID = args[Vxess_ID]
+		dst.Flow = args[Vless_Flow]
+		break;
+	case "vmess":
+		dst.Type = "vmess"
+		dst.UUID = args[Vxess_ID]
+		dst.Security = args[Vmess_Sec]
+		dst.AlterID, _ = strconv.Atoi(args[Vmess_AlterID])
+		break;
+	case "trojan":
+		dst.Type = "trojan"
+		dst.Password = args[Trojan_Password]
+		break;
+	case "ss", "shadowsocks":
+		dst.Type = "shadowsocks"
+		dst.Method = args[SS_Method]
+		dst.Password = args[SS_Password]
+		return dst, nil // no stream settings
+	case "hysteria2", "hy2":
+		dst.Type = "hysteria2"
+		dst.Password = args[Hy2_Auth]
+		if "" != args[Hy2_Ports] {
+			dst.ServerPorts = csv2list(strings.ReplaceAll(args[Hy2_Ports], "-", ":"))
+		}
+		dst.UpMbps = singbox_mbps(args[Hy2_Up])
+		dst.DownMbps = singbox_mbps(args[Hy2_Down])
+		if "" != args[Hy2_Obfs] {
+			dst.Obfs = &SingboxObfs{Type: args[Hy2_Obfs], Password: args[Hy2_ObfsPassword]}
+		}
+		dst.TLS = gen_singbox_tls(args)
+		return dst, nil
+	case "socks":
+		dst.Type = "socks"
+		dst.Version = "5"
+		dst.Username = args[Proxy_User]
+		dst.Password = args[Proxy_Pass]
+		break;
+	case "http":
+		dst.Type = "http"
+		dst.Username = args[Proxy_User]
+		dst.Password = args[Proxy_Pass]
+		break;
+	default:
+		return nil, not_implemented ("sing-box outbound type " + args[Protocol])
+	}
+
+	if dst.Transport, e = gen_singbox_transport(args); nil != e {
+		return nil, e
+	}
+	switch (args[Security]) {
+	case "tls":
+		dst.TLS = gen_singbox_tls(args)
+		break;
+	case "reality":
+		dst.TLS = &SingboxTLS{
+			Enabled: true,
+			ServerName: args[REALITY_sni],
+			Reality: &SingboxReality{
+				Enabled: true,
+				PublicKey: args[REALITY_PublicKey],
+				ShortID: args[REALITY_ShortID],
+			},
+		}
+		if "" != args[REALITY_fp] {
+			dst.TLS.UTLS = &SingboxUTLS{Enabled: true, Fingerprint: args[REALITY_fp]}
+		}
+		break;
+	}
+	return dst, nil
+}
+
+func gen_singbox_tls(args URLmap) *SingboxTLS {
+	res := &SingboxTLS{
+		Enabled: true,
+		ServerName: args[TLS_sni],
+		Insecure: "true" == cbool(args[TLS_AllowInsecure]),
+	}
+	if "" != args[TLS_ALPN] {
+		res.ALPN = csv2list(args[TLS_ALPN])
+	}
+	if "" != args[TLS_fp] {
+		res.UTLS = &SingboxUTLS{Enabled: true, Fingerprint: args[TLS_fp]}
+	}
+	return res
+}
+
+func gen_singbox_transport(args URLmap) (*SingboxTransport, error) {
+	switch (args[Network]) {
+	case "tcp", "":
+		if "http" == args[TCP_HeaderType] {
+			return nil, not_implemented ("sing-box tcp http header")
+		}
+		return nil, nil
+	case "ws":
+		res := &SingboxTransport{Type: "ws", Path: args[WS_Path]}
+		if "" != args[WS_Host] {
+			res.Headers = map[string]string{"Host": args[WS_Host]}
+		}
+		return res, nil
+	case "httpupgrade":
+		return &SingboxTransport{
+			Type: "httpupgrade", Path: args[HTTPUP_Path], Host: args[HTTPUP_Host],
+		}, nil
+	case "grpc":
+		return &SingboxTransport{Type: "grpc", ServiceName: args[GRPC_ServiceName]}, nil
+	}
+	return nil, not_implemented ("sing-box transport " + args[Network])
+}
+
+// Makes sing-box wireguard endpoint of URLmap @args
+// @args should be normalized by Gen_outbound
+func Gen_singbox_endpoint(args URLmap) (*SingboxEndpoint, error) {
+	port, e := parse_port (args[ServerPort])
+	if nil != e {
+		return nil, e
+	}
+	peer := SingboxPeer{
+		Address: args[ServerAddress],
+		Port: port,
+		PublicKey: args[WG_PublicKey],
+		PreSharedKey: args[WG_PreSharedKey],
+		AllowedIPs: []string{"0.0.0.0/0", "::/0"},
+	}
+	for _, s := range csv2list (args[WG_Reserved]) {
+		n, e := parse_int ("reserved", s)
+		if nil != e {
+			return nil, e
+		}
+		peer.Reserved = append(peer.Reserved, n)
+	}
+	dst := &SingboxEndpoint{
+		Type: "wireguard",
+		Tag: args[Remark],
+		Address: csv2list (args[WG_Address]),
+		PrivateKey: args[WG_SecretKey],
+		Peers: []SingboxPeer{peer},
+	}
+	dst.MTU, _ = strconv.Atoi(args[WG_MTU])
+	if "" == dst.Tag {
+		dst.Tag = host_port (peer.Address, port)
+	}
+	return dst, nil
+}
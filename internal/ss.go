@@ -13,35 +13,25 @@ import (
 
 func Gen_ss(args URLmap) (dst *conf.OutboundDetourConfig, e error) {
     map_normal (args, ServerPort, "443")
-	dst = &conf.OutboundDetourConfig{}
-    if e = unmarshal_H (dst,
-        fmt.Sprintf (
-			`{
-                "protocol": "shadowsocks",
-                "settings": {
-                  "servers": [
-                      {
-                          "address": "%s",
-                          "port": %s,
-                          "method": "%s",
-                          "password": "%s"
-                      }
-                  ]
-                },
-                "tag": "proxy"
-             }`,
-			args[ServerAddress], args[ServerPort],
-			args[SS_Method], args[SS_Password],
-		),
-	); nil != e {
+	server := ShadojanServer{
+		Address: args[ServerAddress],
+		Method: args[SS_Method],
+		Password: args[SS_Password],
+	}
+	if server.Port, e = parse_port (args[ServerPort]); nil != e {
+		return
+	}
+	settings := SSCFG{Servers: []ShadojanServer{server}}
+	if dst, e = gen_outbound_detour ("shadowsocks", settings); nil != e {
 		// log
+		return
 	}
 	if dst.StreamSetting, e = Gen_streamSettings (args); nil != e {
         // log
 		return
     }
 	return
-} 
+}
 
 func Gen_ss_URL(src *conf.OutboundDetourConfig) *url.URL {
 	var ss SSCFG;
@@ -57,7 +47,7 @@ func Gen_ss_URL(src *conf.OutboundDetourConfig) *url.URL {
 	u.User = url.User (base64.StdEncoding.EncodeToString (
 		[]byte(fmt.Sprintf ("%s:%s", server.Method, server.Password)),
 	))
-	u.Host = fmt.Sprintf ("%s:%d", server.Address, server.Port)
+	u.Host = host_port (server.Address, server.Port)
 
 	// q := u.Query()
 	// Init_ssURL_stream(src.StreamSetting, u.Query());
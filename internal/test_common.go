@@ -83,49 +83,12 @@ type OutboundDetourConfig[SETTINGS any] struct {
 	StreamSetting  *StreamConfig            `json:"streamSettings"`
 }
 
-// vnext for vless/vmess, account can be any of xxxAccount types
-type VXessOutboundConfig[T any] struct {
-	Vnext []T						`json:"vnext"`
-}
-// for shadowsocks and trojan
-type ServerConfig[T any] struct {
-	Servers  []T                    `json:"servers"`
-}
-
-type VXessOutboundVnext[ACCOUNT any] struct {
-	Address string					`json:"address"`
-	Port    int						`json:"port"`
-	Users   []ACCOUNT				`json:"users"`
-}
-
-type ShadojanServer struct { // shadowsocks and trojan!
-	Address  string	                `json:"address"`
-	Port     int		            `json:"port"`
-	Method   string	                `json:"method"`
-	Password string                 `json:"password"`
-}
-
-type VMessAccount struct {
-	ID          string				`json:"id"`
-	AlterIds    int					`json:"alterId"`
-	Security    string				`json:"security"`
-}
-type VLessAccount struct {
-	ID          string				`json:"id"`
-	Encryption  string				`json:"encryption"`
-	Flow        string              `json:"flow"`
-	Level       int					`json:"level"`
-}
-
 // Complete types to be used in testings
-type VLessVnext VXessOutboundConfig[VXessOutboundVnext[VLessAccount]]
 type VLessCFG OutboundDetourConfig[VLessVnext]
-
-type VmessVnext VXessOutboundConfig[VXessOutboundVnext[VMessAccount]]
 type VMessCFG OutboundDetourConfig[VmessVnext]
-
-type SSCFG ServerConfig[ShadojanServer]
-type TrojanCFG ServerConfig[ShadojanServer]
+type Hysteria2OutboundCFG OutboundDetourConfig[Hysteria2CFG]
+type WireGuardOutboundCFG OutboundDetourConfig[WireGuardCFG]
+type ProxyOutboundCFG OutboundDetourConfig[ProxyCFG]
 type ServerCFG OutboundDetourConfig[ServerConfig[ShadojanServer]]
 
 type StreamConfig struct {
@@ -139,6 +102,8 @@ type StreamConfig struct {
 	Hy2Settings         *Hy2Config           `json:"hy2Settings"`
 	QUICSettings        *QUICConfig          `json:"quicSettings"`
 	GRPCConfig          *GunConfig           `json:"grpcSettings"`
+	HysteriaSettings    *HysteriaConfig      `json:"hysteriaSettings"`
+	FinalMask           *FinalMaskConfig     `json:"finalmask"`
 }
 
 type TCPConfig struct {
@@ -174,6 +139,21 @@ type Hy2Config struct {
 	Password         string			`json:"password"`
 	UseUDPExtension  bool			`json:"use_udp_extension"`
 }
+type HysteriaConfig struct {
+	Version          int            `json:"version"`
+	Auth             string         `json:"auth"`
+	Up               string         `json:"up"`
+	Down             string         `json:"down"`
+	UdpHop struct {
+		Port string                 `json:"port"`
+	}                               `json:"udphop"`
+}
+type FinalMaskConfig struct {
+	Udp []struct {
+		Type     string             `json:"type"`
+		Settings map[string]string  `json:"settings"`
+	}                               `json:"udp"`
+}
 type QUICConfig struct {
 	Header   map[string]string		`json:"header"`
 	Security string					`json:"security"`
@@ -2,7 +2,6 @@
 package internal
 
 import (
-	"fmt"
 	"net/url"
 	"encoding/json"
 	"github.com/xtls/xray-core/infra/conf"
@@ -10,33 +9,24 @@ import (
 
 func Gen_trojan (args URLmap) (dst *conf.OutboundDetourConfig, e error) {
     map_normal (args, ServerPort, "443")
-	dst = &conf.OutboundDetourConfig{}
-    if e = unmarshal_H (dst,
-        fmt.Sprintf (
-			`{
-                "protocol": "trojan",
-                "settings": {
-                  "servers": [
-                      {
-                          "address": "%s",
-                          "port": %s,
-                          "password": "%s"
-                      }
-                  ]
-                },
-                "tag": "proxy"
-             }`,
-			args[ServerAddress], args[ServerPort], args[Trojan_Password],
-		),
-	); nil != e {
+	server := ShadojanServer{
+		Address: args[ServerAddress],
+		Password: args[Trojan_Password],
+	}
+	if server.Port, e = parse_port (args[ServerPort]); nil != e {
+		return
+	}
+	settings := TrojanCFG{Servers: []ShadojanServer{server}}
+	if dst, e = gen_outbound_detour ("trojan", settings); nil != e {
 		// log
+		return
 	}
 	if dst.StreamSetting, e = Gen_streamSettings (args); nil != e {
         // log
 		return
     }
 	return
-} 
+}
 
 func Gen_trojan_URL(src *conf.OutboundDetourConfig) *url.URL {
 	var trojan TrojanCFG;
@@ -51,7 +41,7 @@ func Gen_trojan_URL(src *conf.OutboundDetourConfig) *url.URL {
 	server := trojan.Servers[0]
 	q := u.Query()
 	u.User = url.User(server.Password)
-	u.Host = fmt.Sprintf ("%s:%d", server.Address, server.Port)
+	u.Host = host_port (server.Address, server.Port)
 	Init_trojanURL_stream (src.StreamSetting, q);
 
 	u.RawQuery = q.Encode()
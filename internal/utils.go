@@ -4,6 +4,8 @@ package internal
 import (
 	"io"
 	"net/url"
+	"encoding/json"
+
 	log "github.com/siamak-amo/v2utils/log"
 	"github.com/xtls/xray-core/infra/conf"
 )
@@ -75,23 +77,114 @@ func Gen_outbound(args URLmap) (dst []conf.OutboundDetourConfig, e error) {
 		}
 		break
 
+	case "hysteria2", "hy2":
+		v, e := Gen_hysteria2 (args)
+		if e == nil {
+			dst = append (dst, *v)
+		} else {
+			log.Errorf("Hysteria2 Error:  %v\n", e)
+			return nil, e
+		}
+		break
+
+	case "wireguard", "wg":
+		v, e := Gen_wireguard (args)
+		if e == nil {
+			dst = append (dst, *v)
+		} else {
+			log.Errorf("WireGuard Error:  %v\n", e)
+			return nil, e
+		}
+		break
+
+	case "socks":
+		v, e := Gen_socks (args)
+		if e == nil {
+			dst = append (dst, *v)
+		} else {
+			log.Errorf("Socks Error:  %v\n", e)
+			return nil, e
+		}
+		break
+
+	case "http":
+		v, e := Gen_http (args)
+		if e == nil {
+			dst = append (dst, *v)
+		} else {
+			log.Errorf("HTTP Error:  %v\n", e)
+			return nil, e
+		}
+		break
+
 	default:
 		return nil, not_implemented ("protocol " + args[Protocol])
 	}
+	if e = Set_remark (&dst[0], args[Remark]); nil != e {
+		return nil, e
+	}
 	return
 }
 
 
 func Gen_URL(src *conf.OutboundDetourConfig) *url.URL {
+	var u *url.URL
 	switch (src.Protocol) {
 	case "vless":
-		return Gen_vless_URL(src);
+		u = Gen_vless_URL(src);
 	case "vmess":
-		return Gen_vmess_URL(src);
+		return Gen_vmess_URL(src); // remark is the `ps` key
 	case "ss", "shadowsocks":
-		return Gen_ss_URL(src);
+		u = Gen_ss_URL(src);
 	case "trojan":
-		return Gen_trojan_URL(src);
+		u = Gen_trojan_URL(src);
+	case "hysteria":
+		u = Gen_hysteria2_URL(src);
+	case "wireguard":
+		u = Gen_wireguard_URL(src);
+	case "socks":
+		u = Gen_socks_URL(src);
+	case "http":
+		u = Gen_http_URL(src);
+	}
+	if nil != u {
+		u.Fragment = Get_remark(src)
 	}
+	return u
+}
+
+
+// Remarks (URL #fragment) are kept in the outbound settings
+// as the `remark` key, xray-core ignores it, and unlike the tag,
+// it doesn't affect routing and survives reading the config back
+func Set_remark(dst *conf.OutboundDetourConfig, remark string) error {
+	if "" == remark {
+		return nil
+	}
+	settings := make(map[string]json.RawMessage, 0)
+	if nil != dst.Settings {
+		if e := json.Unmarshal (*dst.Settings, &settings); nil != e {
+			return e
+		}
+	}
+	settings["remark"], _ = json.Marshal (remark)
+	raw, e := json.Marshal (settings)
+	if nil != e {
+		return e
+	}
+	dst.Settings = (*json.RawMessage)(&raw)
 	return nil
 }
+
+func Get_remark(src *conf.OutboundDetourConfig) string {
+	var settings struct {
+		Remark string `json:"remark"`
+	}
+	if nil == src.Settings {
+		return ""
+	}
+	if e := json.Unmarshal (*src.Settings, &settings); nil != e {
+		return ""
+	}
+	return settings.Remark
+}
@@ -2,7 +2,6 @@
 package internal
 
 import (
-	"fmt"
 	"strconv"
 	"net/url"
 	"encoding/json"
@@ -14,25 +13,22 @@ func Gen_vless(args URLmap) (dst *conf.OutboundDetourConfig, e error) {
     map_normal (args, Vless_ENC, "none")
     map_normal (args, ServerPort, "443")
     map_normal (args, Vless_Level, "0")
-    dst = &conf.OutboundDetourConfig{}
-    if e = unmarshal_H (dst,
-        fmt.Sprintf (
-            `{
-                "protocol": "%s",
-                "settings": {"vnext": [
-                  {
-                    "address": "%s",
-                    "port": %s,
-                    "users": [{
-                      "encryption": "%s",  "level": %s,  "id": "%s",  "flow": "%s"
-                    }]
-                  }
-                ]},
-                "tag": "proxy"
-             }`,
-            args[Protocol], args[ServerAddress], args[ServerPort],
-            args[Vless_ENC], args[Vless_Level], args[Vxess_ID], args[Vless_Flow],
-        )); nil != e {
+    vnext := VXessOutboundVnext[VLessAccount]{
+        Address: args[ServerAddress],
+        Users: []VLessAccount{{
+            ID: args[Vxess_ID],
+            Encryption: args[Vless_ENC],
+            Flow: args[Vless_Flow],
+        }},
+    }
+    if vnext.Port, e = parse_port (args[ServerPort]); nil != e {
+        return
+    }
+    if vnext.Users[0].Level, e = parse_int ("level", args[Vless_Level]); nil != e {
+        return
+    }
+    settings := VLessVnext{Vnext: []VXessOutboundVnext[VLessAccount]{vnext}}
+    if dst, e = gen_outbound_detour (args[Protocol], settings); nil != e {
         // log
 		return
     }
@@ -55,7 +51,7 @@ func Gen_vless_URL(src *conf.OutboundDetourConfig) *url.URL {
 	}
 	vnext := vless.Vnext[0]
 	u.User = url.User(vnext.Users[0].ID);
-	u.Host = fmt.Sprintf("%s:%d", vnext.Address, vnext.Port)
+	u.Host = host_port (vnext.Address, vnext.Port)
 
 	q := u.Query()
 	AddQuery (q, "level", strconv.Itoa(vnext.Users[0].Level))
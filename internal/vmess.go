@@ -2,7 +2,6 @@
 package internal
 
 import (
-	"fmt"
 	"strconv"
 
 	"net/url"
@@ -17,25 +16,21 @@ func Gen_vmess(args URLmap) (dst *conf.OutboundDetourConfig, e error) {
     map_normal (args, Vmess_Sec, "none")
     map_normal (args, ServerPort, "443")
     map_normal (args, Vmess_AlterID, "0")
-    dst = &conf.OutboundDetourConfig{}
-    if e = unmarshal_H (dst,
-        fmt.Sprintf (
-            `{
-                "protocol": "%s",
-                "settings": {"vnext": [
-                  {
-                    "address": "%s",
-                    "port": %s,
-                    "users": [{
-                      "security": "%s",  "alterId": %s,  "id": "%s"
-                    }]
-                  }
-                ]},
-                "tag": "proxy"
-             }`,
-            args[Protocol], args[ServerAddress], args[ServerPort],
-            args[Vmess_Sec], args[Vmess_AlterID], args[Vxess_ID],
-        )); nil != e {
+    vnext := VXessOutboundVnext[VMessAccount]{
+        Address: args[ServerAddress],
+        Users: []VMessAccount{{
+            ID: args[Vxess_ID],
+            Security: args[Vmess_Sec],
+        }},
+    }
+    if vnext.Port, e = parse_port (args[ServerPort]); nil != e {
+        return
+    }
+    if vnext.Users[0].AlterIds, e = parse_int ("alterId", args[Vmess_AlterID]); nil != e {
+        return
+    }
+    settings := VmessVnext{Vnext: []VXessOutboundVnext[VMessAccount]{vnext}}
+    if dst, e = gen_outbound_detour (args[Protocol], settings); nil != e {
         // log
 		return
     }
@@ -61,6 +56,9 @@ func Gen_vmess_URL(src *conf.OutboundDetourConfig) *url.URL {
 	res["id"] = vnext.Users[0].ID
 	res["aid"] = strconv.Itoa(vnext.Users[0].AlterIds)
 	res["scy"] = vnext.Users[0].Security
+	if remark := Get_remark (src); "" != remark {
+		res["ps"] = remark
+	}
 	Init_vmessURL_stream (src.StreamSetting, res);
 	
 	j, err := json.Marshal(res);
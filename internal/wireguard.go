@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package internal
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"net/url"
+	"encoding/json"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+func Gen_wireguard (args URLmap) (dst *conf.OutboundDetourConfig, e error) {
+    map_normal (args, ServerPort, "443")
+    map_normal (args, WG_MTU, "1420")
+	settings := WireGuardCFG{
+		SecretKey: args[WG_SecretKey],
+		Address: csv2list (args[WG_Address]),
+		Peers: []WireGuardPeer{{
+			PublicKey: args[WG_PublicKey],
+			PreSharedKey: args[WG_PreSharedKey],
+			Endpoint: net.JoinHostPort (args[ServerAddress], args[ServerPort]),
+		}},
+	}
+	if settings.MTU, e = parse_int ("mtu", args[WG_MTU]); nil != e {
+		return
+	}
+	for _, b := range csv2list (args[WG_Reserved]) {
+		var i int
+		if i, e = parse_int ("reserved", b); nil != e {
+			return
+		}
+		settings.Reserved = append (settings.Reserved, i)
+	}
+	if dst, e = gen_outbound_detour ("wireguard", settings); nil != e {
+		// log
+		return
+	}
+	// WireGuard has no stream settings
+	return
+}
+
+func Gen_wireguard_URL(src *conf.OutboundDetourConfig) *url.URL {
+	var wg WireGuardCFG;
+	if e := json.Unmarshal (*src.Settings, &wg); nil != e {
+		return nil
+	}
+	u := &url.URL{ Scheme: "wireguard" }
+	if 0 == len(wg.Peers) {
+		return nil
+	}
+
+	peer := wg.Peers[0]
+	u.User = url.User(wg.SecretKey)
+	u.Host = peer.Endpoint
+
+	q := u.Query()
+	AddQuery (q, "publickey", peer.PublicKey)
+	AddQuery (q, "presharedkey", peer.PreSharedKey)
+	AddQuery (q, "address", strings.Join(wg.Address, ","))
+	if 0 != wg.MTU {
+		AddQuery (q, "mtu", strconv.Itoa(wg.MTU))
+	}
+	if 0 != len(wg.Reserved) {
+		reserved := make([]string, len(wg.Reserved))
+		for i, b := range wg.Reserved {
+			reserved[i] = strconv.Itoa(b)
+		}
+		AddQuery (q, "reserved", strings.Join(reserved, ","))
+	}
+
+	u.RawQuery = q.Encode()
+	return u
+}
@@ -0,0 +1,143 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package internal
+
+import (
+	"fmt"
+	"testing"
+	"net/url"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+const (
+	WG_Test_SecretKey = "zi8gW7Dw9dhsdaYlbqMCMLetn2inqsQkWhte7QmAaTo="
+	WG_Test_PublicKey = "mFBAwuvOcJx/vVI8vfDXn6vL/KepTw8Ti0yk1Wd0UHI="
+	WG_Test_PSK       = "j6p86YJwb+zG1adlXUHpRPX1MeqUT6UX9UhlUqAozS0="
+)
+
+func TestGen_wireguard(t *testing.T) {
+	tc := TestCase[WireGuardOutboundCFG] {T: t,
+		Input: map[URLMapper]string {
+				Protocol:         "wireguard",
+				ServerAddress:    "vpn.net",
+				ServerPort:       "51820",
+				WG_SecretKey:     WG_Test_SecretKey,
+				WG_PublicKey:     WG_Test_PublicKey,
+				WG_PreSharedKey:  WG_Test_PSK,
+				WG_Address:       "10.0.0.2/32,fd00::2/128",
+				WG_MTU:           "1280",
+				WG_Reserved:      "1,2,3",
+			},
+		Output: WireGuardOutboundCFG{},
+	}
+
+	v, e := Gen_wireguard (tc.Input)
+	if nil != e {
+		t.Fatalf ("gen_wireguard failed: %v\n", e)
+		return
+	}
+	if _, e = v.Build(); nil != e {
+		t.Fatalf ("Build failed: %v\n", e)
+	}
+	tc.Do(v);
+
+	wg := tc.Output.Settings
+	tc.Assert (tc.Output.Protocol,        tc.Input[Protocol])
+	tc.Assert (wg.SecretKey,              tc.Input[WG_SecretKey])
+	tc.Assert (wg.Address[0],             "10.0.0.2/32")
+	tc.Assert (wg.Address[1],             "fd00::2/128")
+	tc.Assert (wg.MTU,                    tc.Input[WG_MTU])
+	tc.Assert (wg.Reserved[2],            "3")
+	tc.Assert (wg.Peers[0].PublicKey,     tc.Input[WG_PublicKey])
+	tc.Assert (wg.Peers[0].PreSharedKey,  tc.Input[WG_PreSharedKey])
+	tc.Assert (wg.Peers[0].Endpoint,      "vpn.net:51820")
+}
+
+func Test_parse_wireguard_url_1 (t *testing.T) {
+	WG_TEST_1 := fmt.Sprintf (
+		"wireguard://%s@1.2.3.4:51820?publickey=%s&address=10.0.0.2%%2F32&mtu=1280&reserved=0,0,0#WG",
+		url.PathEscape(WG_Test_SecretKey), url.QueryEscape(WG_Test_PublicKey),
+	);
+	umap, e := ParseURL(WG_TEST_1);
+	if nil != e {
+		t.Fatalf ("parse_wireguard_url failed: %v\n", e)
+	}
+
+	umap.Assert (t, Protocol,             "wireguard")
+	umap.Assert (t, ServerAddress,        "1.2.3.4")
+	umap.Assert (t, ServerPort,           "51820")
+	umap.Assert (t, WG_SecretKey,         WG_Test_SecretKey)
+	umap.Assert (t, WG_PublicKey,         WG_Test_PublicKey)
+	umap.Assert (t, WG_Address,           "10.0.0.2/32")
+	umap.Assert (t, WG_MTU,               "1280")
+	umap.Assert (t, WG_Reserved,          "0,0,0")
+}
+
+// The wg:// alias, with unescaped keys
+func Test_parse_wireguard_url_2 (t *testing.T) {
+	umap, e := ParseURL("wg://key@vpn.net:1234?presharedkey=p+s/k=");
+	if nil != e {
+		t.Fatalf ("parse_wireguard_url failed: %v\n", e)
+	}
+	umap.Assert (t, Protocol,             "wireguard")
+	umap.Assert (t, WG_SecretKey,         "key")
+	umap.Assert (t, WG_PreSharedKey,      "p+s/k=")
+}
+
+// Test URL generator
+func Test_Gen_wireguard_URL_1(t *testing.T) {
+	cfg := &conf.OutboundDetourConfig{ Protocol: "wireguard" }
+	if e := unmarshal_H (cfg, fmt.Sprintf(`
+		{
+            "protocol": "wireguard", "settings": {
+                "secretKey": "%s", "address": ["10.0.0.2/32", "fd00::2/128"],
+                "peers": [{"publicKey": "%s", "endpoint": "1.2.3.4:51820"}],
+                "mtu": 1280, "reserved": [1, 2, 3]
+            }
+        }`, WG_Test_SecretKey, WG_Test_PublicKey,
+	)); nil != e {
+		panic (e);
+	}
+	u := Gen_URL (cfg);
+	if nil == u {
+		t.Fatal("failed")
+	}
+
+	q := u.Query()
+	Assert (t, u.Scheme, "wireguard");
+	Assert (t, u.User.Username(), WG_Test_SecretKey);
+	Assert (t, u.Host, "1.2.3.4:51820");
+	Assert (t, q.Get("publickey"), WG_Test_PublicKey);
+	Assert (t, q.Get("presharedkey"), "");
+	Assert (t, q.Get("address"), "10.0.0.2/32,fd00::2/128");
+	Assert (t, q.Get("mtu"), "1280");
+	Assert (t, q.Get("reserved"), "1,2,3");
+}
+
+// URL -> json -> URL
+func Test_wireguard_round_trip(t *testing.T) {
+	src := &url.URL{
+		Scheme: "wireguard",
+		User: url.User(WG_Test_SecretKey),
+		Host: "vpn.net:51820",
+		RawQuery: url.Values{
+			"publickey": {WG_Test_PublicKey},
+			"presharedkey": {WG_Test_PSK},
+			"address": {"10.0.0.2/32"},
+			"mtu": {"1280"},
+			"reserved": {"1,2,3"},
+		}.Encode(),
+	}
+	umap, e := ParseURL(src.String());
+	if nil != e {
+		t.Fatalf ("ParseURL failed: %v\n", e)
+	}
+	v, e := Gen_outbound (umap)
+	if nil != e {
+		t.Fatalf ("Gen_outbound failed: %v\n", e)
+	}
+	u := Gen_URL (&v[0]);
+	if nil == u {
+		t.Fatal("failed")
+	}
+	Assert (t, u.String(), src.String());
+}
@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"fmt"
+	"time"
+	"errors"
+	"strings"
+	"encoding/json"
+
+	log "github.com/siamak-amo/v2utils/log"
+
+	"github.com/xtls/xray-core/infra/conf"
+	"github.com/xtls/xray-core/infra/conf/cfgcommon/duration"
+)
+
+var (
+	// Tag of the balancer and prefix of its outbound tags
+	// Selectors of xray-core match the prefix, so the prefix is
+	// unlike tags of users (e.g. proxy-de) in templates
+	BalancerTag = "balancer"
+	BalancerOutboundPrefix = "v2utils-balancer-"
+
+	// Observatory settings, to find out alive outbounds
+	BalancerProbeURL = "https://www.google.com/generate_204"
+	BalancerProbeInterval = time.Minute
+
+	Balancer_Strategies = []string{"random", "roundRobin", "leastPing", "leastLoad"}
+)
+
+// Returns the exact name of balancer @strategy (case insensitive)
+func Balancer_Strategy(strategy string) (string, error) {
+	for _, s := range Balancer_Strategies {
+		if strings.EqualFold(s, strategy) {
+			return s, nil
+		}
+	}
+	return "", errors.New("invalid balancer strategy: " + strategy)
+}
+
+// Initializes @v2.CFG.OutboundConfigs by all the proxy URLs @urls,
+// with a balancer (@strategy) and an observatory over them
+// Outbounds are tagged by BalancerOutboundPrefix and numbers, and
+// broken or invalid URLs are skipped with a warning
+// They replace the placeholder outbound of the template, and its
+// routing rules go to the balancer (see PlaceholderTag)
+func (v2 *V2utils) Init_Outbounds_byURLs(urls []string, strategy string) error {
+	strategy, e := Balancer_Strategy(strategy);
+	if nil != e {
+		return e
+	}
+	for _, ob := range v2.template_outbounds {
+		if strings.HasPrefix(ob.Tag, BalancerOutboundPrefix) {
+			return fmt.Errorf("template outbound '%s' would be selected by the balancer", ob.Tag)
+		}
+	}
+	var outbounds []conf.OutboundDetourConfig
+	for _, url := range urls {
+		v, e := Gen_Outbound_byURL(url);
+		if nil == e {
+			// A broken outbound, breaks the whole config
+			_, e = v[0].Build();
+		}
+		if nil != e {
+			log.Warnf("URL '%s' was ignored - %v\n", url, e);
+			continue;
+		}
+		v[0].Tag = fmt.Sprintf("%s%d", BalancerOutboundPrefix, len(outbounds) + 1)
+		outbounds = append(outbounds, v[0])
+	}
+	if 0 == len(outbounds) {
+		return errors.New("No valid URL was provided")
+	}
+	v2.set_outbounds(outbounds);
+
+	if e = v2.set_balancer(strategy); nil != e {
+		return e
+	}
+	if e = v2.set_observatory(strategy); nil != e {
+		return e
+	}
+	return v2.Check_Routing();
+}
+
+// Adds the balancer and a rule to route all traffics to it,
+// after the template's rules
+func (v2 *V2utils) set_balancer(strategy string) error {
+	if nil == v2.CFG.RouterConfig {
+		v2.CFG.RouterConfig = &conf.RouterConfig{}
+	}
+	if e := v2.route_placeholder(BalancerTag); nil != e {
+		return e
+	}
+	router := v2.CFG.RouterConfig
+	router.Balancers = append(router.Balancers, &conf.BalancingRule{
+		Tag: BalancerTag,
+		Selectors: conf.StringList{BalancerOutboundPrefix},
+		Strategy: conf.StrategyConfig{Type: strategy},
+	})
+	rule, e := json.Marshal(map[string]string{
+		"type": "field",
+		"network": "tcp,udp",
+		"balancerTag": BalancerTag,
+	})
+	if nil != e {
+		return e
+	}
+	router.RuleList = append(router.RuleList, rule)
+	return nil
+}
+
+// leastLoad needs burstObservatory, the others use observatory
+// to skip dead outbounds
+func (v2 *V2utils) set_observatory(strategy string) error {
+	selector := []string{BalancerOutboundPrefix}
+	if "leastLoad" != strategy {
+		v2.CFG.Observatory = &conf.ObservatoryConfig{
+			SubjectSelector: selector,
+			ProbeURL: BalancerProbeURL,
+			ProbeInterval: duration.Duration(BalancerProbeInterval),
+		}
+		return nil
+	}
+	// pingConfig type is not exported
+	burst, e := json.Marshal(map[string]any{
+		"subjectSelector": selector,
+		"pingConfig": map[string]any{
+			"destination": BalancerProbeURL,
+			"interval": BalancerProbeInterval.String(),
+		},
+	})
+	if nil != e {
+		return e
+	}
+	v2.CFG.BurstObservatory = &conf.BurstObservatoryConfig{}
+	return json.Unmarshal(burst, v2.CFG.BurstObservatory);
+}
@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestInit_Outbounds_byURLs(t *testing.T) {
+	urls := append(sub_links, "unsupported://x")
+	for _, strategy := range []string{"leastping", "random", "roundRobin", "leastLoad"} {
+		v2 := V2utils{}
+		v2.Apply_template_bystr(DEF_Run_Template)
+		if e := v2.Init_Outbounds_byURLs(urls, strategy); nil != e {
+			t.Fatalf("%s: Init_Outbounds_byURLs failed: %v\n", strategy, e)
+		}
+		if 3 != len(v2.CFG.OutboundConfigs) {
+			t.Fatalf("%s: expected 3 outbounds, got %d\n", strategy, len(v2.CFG.OutboundConfigs))
+		}
+		for i, tag := range []string{"v2utils-balancer-1", "v2utils-balancer-2", "v2utils-balancer-3"} {
+			if v2.CFG.OutboundConfigs[i].Tag != tag {
+				t.Fatalf("%s: outbound #%d: expected tag '%s', got '%s'\n",
+					strategy, i, tag, v2.CFG.OutboundConfigs[i].Tag)
+			}
+		}
+		if nil == v2.CFG.Observatory && nil == v2.CFG.BurstObservatory {
+			t.Fatalf("%s: no observatory\n", strategy)
+		}
+		if _, e := v2.CFG.Build(); nil != e {
+			t.Fatalf("%s: Build failed: %v\n", strategy, e)
+		}
+
+		// The output must be loadable
+		var out bytes.Buffer
+		if e := v2.CFG_Out(&out, false); nil != e {
+			t.Fatalf("%s: CFG_Out failed: %v\n", strategy, e)
+		}
+		if e := v2.Apply_template_bystr(out.String()); nil != e {
+			t.Fatalf("%s: loading output failed: %v\n", strategy, e)
+		}
+		if _, e := v2.CFG.Build(); nil != e {
+			t.Fatalf("%s: Build of output failed: %v\n", strategy, e)
+		}
+	}
+
+	v2 := V2utils{}
+	v2.Apply_template_bystr(DEF_Run_Template)
+	if e := v2.Init_Outbounds_byURLs(urls, "fastest"); nil == e {
+		t.Fatalf("invalid strategy was accepted\n")
+	}
+	if e := v2.Init_Outbounds_byURLs([]string{"x://y"}, "random"); nil == e {
+		t.Fatalf("no valid URL was accepted\n")
+	}
+
+	// Template outbounds must not be selected by the balancer
+	v2.Apply_template_bystr(`{"outbounds": [
+	    {"tag": "proxy-de", "protocol": "freedom"},
+	    {"tag": "v2utils-balancer-x", "protocol": "freedom"}
+	]}`)
+	if e := v2.Init_Outbounds_byURLs(urls, "random"); nil == e {
+		t.Fatalf("template outbound with the balancer prefix was accepted\n")
+	}
+}
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/siamak-amo/v2utils/internal"
+
+	conf "github.com/xtls/xray-core/infra/conf"
+)
+
+// Tests many proxy URLs using a single xray-core instance
+// Each URL gets an outbound with a unique tag, and the tests
+// dial through these outbounds by their tag (see CustomDial),
+// so starting and killing xray-core happens once per batch.
+type BatchTester struct {
+	Workers int            // number of concurrent tests on the instance
+	Tester ConnectivityTester_I
+};
+
+func NewBatchTester(workers int, tester ConnectivityTester_I) *BatchTester {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &BatchTester{
+		Workers: workers,
+		Tester: tester,
+	};
+}
+
+func batch_tag(idx int) string {
+	return fmt.Sprintf("batch-%d", idx);
+}
+
+// Makes the same config as Test_URL for a single outbound
+func batch_single_cfg(outbound conf.OutboundDetourConfig) *conf.Config {
+	c, e := internal.Gen_main(DEF_Test_Template);
+	if nil != e {
+		panic(e); // it's ours, broken default template
+	}
+	c.OutboundConfigs = []conf.OutboundDetourConfig{outbound}
+	return c
+}
+
+// Tests URL jobs (TestJob_URL) of @jobs (blocking)
+// Invalid URLs and outbounds fail individually, without
+// affecting the other jobs of the batch.
+func (b *BatchTester) Test(jobs []*TestJob) {
+	v2 := &V2utils{}
+	v2.Apply_template_bystr(DEF_Test_Template);
+	defer v2.UnsetTemplate()
+
+	tags := make([]string, len(jobs))
+	for i, job := range jobs {
+		job.Err, job.Result, job.CFG = nil, nil, nil
+		if TestJob_URL != job.Type {
+			job.Err = Not_Supported_Error
+			continue;
+		}
+		outbounds, e := Gen_Outbound_byURL(job.Input);
+		if nil != e {
+			job.Err = e
+			continue;
+		}
+		// A broken outbound, breaks the whole instance
+		if _, e = outbounds[0].Build(); nil != e {
+			job.Err = e
+			continue;
+		}
+		job.CFG = batch_single_cfg(outbounds[0])
+
+		tags[i] = batch_tag(i)
+		outbounds[0].Tag = tags[i]
+		v2.CFG.OutboundConfigs = append(v2.CFG.OutboundConfigs, outbounds[0])
+	}
+	if 0 == len(v2.CFG.OutboundConfigs) {
+		return;
+	}
+
+	if e := v2.Run_Xray(); nil != e {
+		for i, job := range jobs {
+			if "" != tags[i] {
+				job.Err = e
+			}
+		}
+		return;
+	}
+	defer v2.Kill_Xray();
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, b.Workers)
+	for i, job := range jobs {
+		if "" == tags[i] {
+			continue;
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(job *TestJob, tag string) {
+			defer func() { <-sem; wg.Done() }()
+			v := *v2
+			v.outbound_tag = tag
+			job.Err, job.Result = b.Tester.Test(&v);
+		}(job, tags[i])
+	}
+	wg.Wait()
+}
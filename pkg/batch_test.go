@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"errors"
+	"testing"
+	"encoding/json"
+
+	"github.com/siamak-amo/v2utils/internal"
+)
+
+// Fails on odd ports of the outbound @v2.outbound_tag
+type Tag_Contester struct{}
+
+func (tester Tag_Contester) Test(v2 *V2utils) (error, *TestResult) {
+	for _, ob := range v2.CFG.OutboundConfigs {
+		if ob.Tag != v2.outbound_tag {
+			continue;
+		}
+		var vless internal.VLessVnext
+		if e := json.Unmarshal(*ob.Settings, &vless); nil != e {
+			return e, nil
+		}
+		if p := vless.Vnext[0].Port; 1 == p % 2 {
+			return errors.New("odd port"), nil
+		} else {
+			return nil, &TestResult{Duration: int64(p)}
+		}
+	}
+	return errors.New("outbound not found: " + v2.outbound_tag), nil
+}
+
+func TestBatchTester(t *testing.T) {
+	jobs := []*TestJob{
+		{Type: TestJob_URL, Input: "vless://id@127.0.0.1:2?type=tcp"},
+		{Type: TestJob_URL, Input: "vless://id@127.0.0.1:3?type=tcp"},
+		{Type: TestJob_URL, Input: "invalid://URL"},
+		{Type: TestJob_CFG, Input: "config.json"},
+		{Type: TestJob_URL, Input: "vless://id@127.0.0.1:4?type=tcp"},
+	}
+	NewBatchTester(2, Tag_Contester{}).Test(jobs)
+
+	if nil != jobs[0].Err || 2 != jobs[0].Result.Duration {
+		t.Fatalf("job #0: unexpected result: %v\n", jobs[0].Err)
+	}
+	if nil == jobs[1].Err {
+		t.Fatalf("job #1: expected failure\n")
+	}
+	if nil == jobs[2].Err {
+		t.Fatalf("job #2: invalid URL passed\n")
+	}
+	if Not_Supported_Error != jobs[3].Err {
+		t.Fatalf("job #3: unexpected error: %v\n", jobs[3].Err)
+	}
+	if nil != jobs[4].Err || 4 != jobs[4].Result.Duration {
+		t.Fatalf("job #4: unexpected result: %v\n", jobs[4].Err)
+	}
+	// Each job must have its own single outbound config
+	if nil == jobs[4].CFG || 1 != len(jobs[4].CFG.OutboundConfigs) {
+		t.Fatalf("job #4: invalid config\n")
+	}
+}
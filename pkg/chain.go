@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"fmt"
+	"errors"
+	"strings"
+
+	"github.com/siamak-amo/v2utils/internal"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// Tag prefix of the middle hops of chains
+// The last hop is tagged `proxy`, like single URLs
+var ChainTagPrefix = "chain-"
+
+// Splits chain of proxy URLs `URL1,URL2,...`
+// URLs may contain comma (e.g. alpn=h2,http/1.1), so only
+// commas followed by a proxy URL separate them (see Is_Proxy_Link)
+func Split_Chain(chain string) []string {
+	var res []string
+	start := 0
+	for i := 0; i < len(chain); i += 1 {
+		if ',' == chain[i] && internal.Is_Proxy_Link(strings.TrimLeft(chain[i+1:], " ")) {
+			res = append(res, strings.TrimSpace(chain[start:i]))
+			start = i + 1
+		}
+	}
+	return append(res, strings.TrimSpace(chain[start:]))
+}
+
+// Generates outbounds of the chain of proxy URLs @urls
+// @urls[0] is the first hop (e.g. a domestic relay) and each hop
+// dials through the previous one, by sockopt.dialerProxy
+// The last hop is the first outbound (the default route)
+func Gen_Outbounds_byChain(urls []string) ([]conf.OutboundDetourConfig, error) {
+	if 0 == len(urls) {
+		return nil, errors.New("Empty chain")
+	}
+	var res []conf.OutboundDetourConfig
+	prev := ""
+	for i, url := range urls {
+		v, e := Gen_Outbound_byURL(url);
+		if nil != e {
+			return nil, fmt.Errorf("hop #%d - %w", i + 1, e)
+		}
+		ob := v[0]
+		if i != len(urls) - 1 {
+			ob.Tag = fmt.Sprintf("%s%d", ChainTagPrefix, i + 1)
+		}
+		if "" != prev {
+			set_dialer_proxy(&ob, prev);
+		}
+		prev = ob.Tag
+		res = append([]conf.OutboundDetourConfig{ob}, res...)
+	}
+	return res, nil
+}
+
+// Makes @dst dial through the outbound @tag
+func set_dialer_proxy(dst *conf.OutboundDetourConfig, tag string) {
+	if nil == dst.StreamSetting {
+		// e.g. wireguard, which has no stream settings
+		dst.ProxySettings = &conf.ProxyConfig{Tag: tag}
+		return
+	}
+	if nil == dst.StreamSetting.SocketSettings {
+		dst.StreamSetting.SocketSettings = &conf.SocketConfig{}
+	}
+	dst.StreamSetting.SocketSettings.DialerProxy = tag
+}
+
+// Initializes @v2.CFG.OutboundConfigs by chain of proxy URLs @urls
+// See Gen_Outbounds_byChain and PlaceholderTag
+func (v2 *V2utils) Init_Outbound_byChain(urls []string) error {
+	outbounds, e := Gen_Outbounds_byChain(urls);
+	if nil != e {
+		return e
+	}
+	v2.set_outbounds(outbounds);
+	return v2.Check_Routing();
+}
+
+// Tests chain of proxy URLs @chain (see Split_Chain),
+// the whole chain, by dialing through the last hop
+// Dials through the hops are canceled and waited for before
+// it returns, even when the test is timed out (see Kill_Xray)
+func (v2 *V2utils) Test_Chain(chain string, tester ConnectivityTester_I) (error, *TestResult) {
+	v2.Apply_template_bystr(DEF_Test_Template);
+	if e := v2.Init_Outbound_byChain(Split_Chain(chain)); nil != e {
+		return e, nil
+	}
+	v2.outbound_tag = v2.CFG.OutboundConfigs[0].Tag
+	defer func() { v2.outbound_tag = "" }()
+	return v2.doTest(tester);
+}
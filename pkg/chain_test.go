@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"sync"
+	"time"
+	"sync/atomic"
+	"testing"
+	"net/http"
+	"net/http/httptest"
+)
+
+func TestSplit_Chain(t *testing.T) {
+	for chain, expected := range map[string][]string{
+		"vless://id@1.2.3.4:443": {"vless://id@1.2.3.4:443"},
+		"socks5://1.1.1.1:1080, vless://id@1.2.3.4:443?alpn=h2,http/1.1#a,b": {
+			"socks5://1.1.1.1:1080", "vless://id@1.2.3.4:443?alpn=h2,http/1.1#a,b",
+		},
+		"http://u:p@a:8080,vless://id@b:443?alpn=h2,https://x.com#https://y.com,z": {
+			"http://u:p@a:8080", "vless://id@b:443?alpn=h2,https://x.com#https://y.com,z",
+		},
+		"trojan://p@a:1,trojan://p@b:2,ss://x@c:3": {
+			"trojan://p@a:1", "trojan://p@b:2", "ss://x@c:3",
+		},
+	} {
+		res := Split_Chain(chain)
+		if len(res) != len(expected) {
+			t.Fatalf("'%s': expected %v, got %v\n", chain, expected, res)
+		}
+		for i := range res {
+			if res[i] != expected[i] {
+				t.Fatalf("'%s': hop #%d: expected '%s', got '%s'\n", chain, i, expected[i], res[i])
+			}
+		}
+	}
+}
+
+// Requests @url through the running instance
+type URL_Contester struct {
+	url string
+}
+
+func (tester URL_Contester) Test(v2 *V2utils) (error, *TestResult) {
+	client := v2.test_client()
+	defer client.CloseIdleConnections()
+	err, duration, _, _ := v2.test_http(client, tester.url, false)
+	return err, &TestResult{Duration: duration}
+}
+
+// Records the target of CONNECT requests
+type connect_recorder struct {
+	sync.Mutex
+	hosts []string
+}
+
+func (r *connect_recorder) proxy() *httptest.Server {
+	var count atomic.Int32
+	proxy := connect_proxy(&count)
+	handler := proxy.Config.Handler
+	proxy.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.Lock()
+		r.hosts = append(r.hosts, req.Host)
+		r.Unlock()
+		handler.ServeHTTP(w, req)
+	})
+	return proxy
+}
+
+func TestTest_Chain(t *testing.T) {
+	srv := sub_server(t)
+	defer srv.Close()
+	var first, last connect_recorder
+	hop1 := first.proxy()
+	defer hop1.Close()
+	hop2 := last.proxy()
+	defer hop2.Close()
+
+	chain := "http://" + hop1.Listener.Addr().String() + ",http://" + hop2.Listener.Addr().String()
+	v2 := &V2utils{}
+	if e, _ := v2.Test_Chain(chain, URL_Contester{srv.URL + "/sub"}); nil != e {
+		t.Fatalf("Test_Chain failed: %v\n", e)
+	}
+	// The first hop tunnels to the last one, the last one to the server
+	if 0 == len(first.hosts) || first.hosts[0] != hop2.Listener.Addr().String() {
+		t.Fatalf("first hop: unexpected tunnels: %v\n", first.hosts)
+	}
+	if 0 == len(last.hosts) || last.hosts[0] != srv.Listener.Addr().String() {
+		t.Fatalf("last hop: unexpected tunnels: %v\n", last.hosts)
+	}
+	if "" != v2.outbound_tag {
+		t.Fatalf("outbound tag was not reset\n")
+	}
+
+	// Broken middle hop breaks the chain
+	timeout := TestTimeout
+	TestTimeout = time.Second
+	hop1.Close()
+	e, _ := v2.Test_Chain(chain, URL_Contester{srv.URL + "/sub"})
+	// Dials of the test have ended, see Kill_Xray
+	TestTimeout = timeout
+	if nil == e {
+		t.Fatalf("Test_Chain succeeded through a closed hop\n")
+	}
+	if nil != v2.Xray_instance || nil != v2.dials {
+		t.Fatalf("the instance was not killed\n")
+	}
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"io"
+
+	"github.com/ghodss/yaml"
+	"github.com/siamak-amo/v2utils/internal"
+	log "github.com/siamak-amo/v2utils/log"
+)
+
+// Clash (Mihomo) proxies file
+// Use Add_xxx functions to add proxies, then Out to make yaml
+type ClashProxies struct {
+	proxy_collector
+}
+
+type clash_format struct {
+	cfg internal.ClashConfig
+}
+
+func NewClashProxies() *ClashProxies {
+	return &ClashProxies{
+		new_proxy_collector(&clash_format{
+			cfg: internal.ClashConfig{Proxies: []internal.ClashProxy{}},
+		}),
+	};
+}
+
+func (c *clash_format) add(umap internal.URLmap, unique func(string) string) error {
+	proxy, e := internal.Gen_clash_proxy(umap);
+	if nil != e {
+		return e
+	}
+	proxy.Name = unique(proxy.Name)
+	c.cfg.Proxies = append(c.cfg.Proxies, *proxy)
+	return nil
+}
+
+func (c clash_format) len() int {
+	return len(c.cfg.Proxies)
+}
+
+func (c clash_format) out(w io.Writer) error {
+	out, e := yaml.Marshal(c.cfg);
+	if nil != e {
+		return e
+	}
+	_, e = w.Write(out);
+	return e
+}
+
+// Converts proxies of Clash (Mihomo) yaml config @data to URL
+// Unsupported proxies are skipped with a warning
+func Parse_Clash(data []byte) ([]string, error) {
+	var cfg internal.ClashConfig
+	if e := yaml.Unmarshal(data, &cfg); nil != e {
+		return nil, e
+	}
+	var res []string
+	for i := range cfg.Proxies {
+		umap, e := internal.Parse_clash_proxy(&cfg.Proxies[i]);
+		var url string
+		if nil == e {
+			url, e = umap2url(umap);
+		}
+		if nil != e {
+			log.Warnf("Clash proxy '%s' was ignored - %v\n", cfg.Proxies[i].Name, e);
+			continue;
+		}
+		res = append(res, url)
+	}
+	return res, nil
+}
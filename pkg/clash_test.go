@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"encoding/base64"
+)
+
+const clash_sample = `# Mihomo config
+mixed-port: 7890
+proxies:
+  - name: "vmess ws"
+    type: vmess
+    server: 1.2.3.4
+    port: "443"
+    uuid: a6a4d1c4-6a2e-4a1e-9a4b-62a3b1a6d8f1
+    alterId: 0
+    cipher: auto
+    tls: true
+    servername: x.com
+    network: ws
+    ws-opts:
+      path: /ws
+      headers:
+        Host: x.com
+  - {name: trojan, type: trojan, server: 1.2.3.4, port: 443, password: pass, sni: t.com}
+  - name: snell
+    type: snell
+    server: 1.2.3.4
+    port: 443
+  - name: ss
+    type: ss
+    server: 1.2.3.4
+    port: 8388
+    cipher: aes-256-gcm
+    password: pass
+proxy-groups: []
+`
+
+func TestParse_Clash(t *testing.T) {
+	expected := []string{
+		"vmess://",
+		"trojan://pass@1.2.3.4:443?alpn=h2%2Chttp%2F1.1&headerType=none&security=tls&sni=t.com&type=tcp#trojan",
+		"ss://YWVzLTI1Ni1nY206cGFzcw==@1.2.3.4:8388#ss",
+	}
+	urls, e := Parse_Clash([]byte(clash_sample))
+	if nil != e {
+		t.Fatalf("Parse_Clash failed: %v\n", e)
+	}
+	if len(urls) != len(expected) { // snell is not supported
+		t.Fatalf("expected %d URLs, got %d: %v\n", len(expected), len(urls), urls)
+	}
+	for i := range urls {
+		if !strings.HasPrefix(urls[i], expected[i]) {
+			t.Fatalf("URL #%d: expected '%s', got '%s'\n", i, expected[i], urls[i])
+		}
+	}
+
+	// Subscriptions, plain and base64
+	sub_assert(t, "clash", clash_sample, urls)
+	sub_assert(t, "clash base64",
+		base64.StdEncoding.EncodeToString([]byte(clash_sample)), urls)
+
+	// And back to Clash
+	c := NewClashProxies()
+	for _, url := range urls {
+		if e = c.Add_URL(url); nil != e {
+			t.Fatalf("Add_URL failed: %v\n", e)
+		}
+	}
+	c.Add_URL(urls[2]) // duplicate name
+	var out bytes.Buffer
+	if e = c.Out(&out); nil != e {
+		t.Fatalf("Out failed: %v\n", e)
+	}
+	res, e := Parse_Clash(out.Bytes())
+	if nil != e {
+		t.Fatalf("Parse_Clash failed: %v\n", e)
+	}
+	if len(res) != 4 || !strings.HasSuffix(res[3], "#ss%202") {
+		t.Fatalf("unexpected round trip: %v\n", res)
+	}
+	for i := range urls {
+		if res[i] != urls[i] {
+			t.Fatalf("URL #%d: expected '%s', got '%s'\n", i, urls[i], res[i])
+		}
+	}
+}
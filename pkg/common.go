@@ -3,8 +3,12 @@ package pkg
 
 import (
 	"io"
+	"os"
+	"fmt"
+	"bytes"
 	"errors"
 	"strings"
+	"path/filepath"
 
 	"github.com/siamak-amo/v2utils/internal"
 
@@ -19,7 +23,14 @@ import (
 type V2utils struct {
 	CFG *conf.Config
 	set_template bool
+	template_outbounds []conf.OutboundDetourConfig // see PlaceholderTag
+	template_inbounds []conf.InboundDetourConfig
 	Xray_instance *core.Instance // xray-core client instance
+	dials *xray_dials // of Xray_instance, see CustomDial
+	concurrent bool // other instances may run at the same time (e.g. TestPool workers)
+	exclusive bool // Xray_instance holds xray_mutex, see Run_Xray
+	outbound_tag string // to force dialing through this outbound
+	Vars map[string]string // template variables, see Expand_template
 };
 
 
@@ -46,6 +57,7 @@ func (v2 *V2utils) UnsetTemplate() {
 	if v2.set_template {
 		v2.CFG = nil;
 		v2.set_template = false;
+		v2.template_outbounds, v2.template_inbounds = nil, nil
 	}
 }
 
@@ -67,41 +79,151 @@ func (v2 *V2utils) SetDefaultInboundConfig() {
 
 // Applies the template v2.template_path to v2.CFG
 func (v2 *V2utils) Apply_template(file_path string) error {
+	c, err := v2.load_template(file_path, true);
+	if nil != err {
+		return err
+	}
+	v2.CFG = c;
+	v2.template_loaded()
+	return nil
+}
+
+// Loads the config file @file_path to v2.CFG, as is
+// Unlike Apply_template, it has no template variables
+func (v2 *V2utils) Load_CFG(file_path string) error {
+	c, err := v2.load_template(file_path, false);
+	if nil != err {
+		return err
+	}
+	v2.CFG = c;
+	v2.template_loaded()
+	return nil
+}
+
+// Applies and merges the templates @paths in order, like xray -confdir
+// Directories are expanded to their config files, sorted by name
+// Inbounds and outbounds with the same tag are replaced, and the others
+// are added (see conf.Config.Override); other sections are replaced
+func (v2 *V2utils) Apply_templates(paths []string) error {
+	files, err := template_files(paths);
+	if nil != err {
+		return err
+	}
+	if 0 == len(files) {
+		return errors.New("no template file was found")
+	}
+	var res *conf.Config
+	for _, file := range files {
+		c, err := v2.load_template(file, true);
+		if nil != err {
+			return fmt.Errorf("%s - %w", file, err)
+		}
+		if nil == res {
+			res = c
+		} else {
+			res.Override(c, file);
+		}
+	}
+	v2.CFG = res;
+	v2.template_loaded()
+	return nil
+}
+
+// Expands directories of @paths to their config files
+func template_files(paths []string) ([]string, error) {
+	var res []string
+	for _, p := range paths {
+		info, e := os.Stat(p);
+		if nil != e {
+			return nil, e
+		}
+		if !info.IsDir() {
+			res = append(res, p)
+			continue;
+		}
+		entries, e := os.ReadDir(p);
+		if nil != e {
+			return nil, e
+		}
+		// ReadDir sorts by filename
+		for _, entry := range entries {
+			f := filepath.Join(p, entry.Name())
+			if entry.IsDir() {
+				continue;
+			}
+			switch GetFormatByExtension(f) {
+			case "json", "yaml", "toml":
+				res = append(res, f)
+			}
+		}
+	}
+	return res, nil
+}
+
+// Loads the template file @file_path
+// @expand: to substitute template variables, see Expand_template
+func (v2 V2utils) load_template(file_path string, expand bool) (*conf.Config, error) {
 	t := core.ConfigSource{
 		Name: file_path,
 		Format: GetFormatByExtension(file_path),
 	}
 	if "" == t.Format {
-		return errors.New("invalid config file extension")
+		return nil, errors.New("invalid config file extension")
 	}
 	r, err := confloader.LoadConfig(t.Name)
 	if nil != err {
-		return err
-	} else {
-		c, err := serial.ReaderDecoderByFormat[t.Format](r)
-		if nil != err {
-			return err
+		return nil, err
+	}
+	if expand {
+		if r, err = v2.expand_reader(r); nil != err {
+			return nil, err
 		}
-		v2.CFG = c;
 	}
-	v2.set_template = true
-	return nil
+	return serial.ReaderDecoderByFormat[t.Format](r);
 }
 
 func (v2 *V2utils) Apply_template_bystr(template string) error {
-	var e error
-	if v2.CFG, e = internal.Gen_main(template); nil != e {
+	expanded, e := v2.Expand_template([]byte(template));
+	if nil != e {
+		return e
+	}
+	if v2.CFG, e = internal.Gen_main(string(expanded)); nil != e {
 		return e;
 	}
-	v2.set_template = true
+	v2.template_loaded()
 	return nil
 }
 
 func (v2 *V2utils) Apply_template_byio(rio io.Reader) error {
+	var err error
+	if rio, err = v2.expand_reader(rio); nil != err {
+		return err
+	}
+	v2.CFG, err = internal.Gen_main_io(rio);
+	if nil == err {
+		v2.template_loaded()
+	}
+	return err;
+}
+
+// Loads config of @rio to v2.CFG, as is, see Load_CFG
+func (v2 *V2utils) Load_CFG_byio(rio io.Reader) error {
 	var err error
 	v2.CFG, err = internal.Gen_main_io(rio);
 	if nil == err {
-		v2.set_template = true
+		v2.template_loaded()
 	}
 	return err;
 }
+
+// Returns reader of @rio with substituted template variables
+func (v2 V2utils) expand_reader(rio io.Reader) (io.Reader, error) {
+	data, e := io.ReadAll(rio);
+	if nil != e {
+		return nil, e
+	}
+	if data, e = v2.Expand_template(data); nil != e {
+		return nil, e
+	}
+	return bytes.NewReader(data), nil
+}
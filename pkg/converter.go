@@ -3,27 +3,57 @@ package pkg
 
 import (
 	"io"
+	"fmt"
+	"path"
+	"bytes"
 	"errors"
+	"slices"
+	"strconv"
+	"strings"
 	"encoding/json"
 
+	"github.com/ghodss/yaml"
+	"github.com/pelletier/go-toml"
+
 	"github.com/siamak-amo/v2utils/internal"
-)
+	log "github.com/siamak-amo/v2utils/log"
 
-// Initializes @v2.CFG.OutboundConfig by the provided proxy URL @url
-func (v2 *V2utils) Init_Outbound_byURL(url string) (error) {
-	var e error
-	var umap internal.URLmap
+	"github.com/xtls/xray-core/infra/conf"
+)
 
+// Generates outbound config of the proxy URL @url
+func Gen_Outbound_byURL(url string) ([]conf.OutboundDetourConfig, error) {
 	// Parse the URL
-	umap, e = internal.ParseURL(url);
+	umap, e := internal.ParseURL(url);
 	if nil != e {
-		return e
+		return nil, e
 	}
 	// Generate outbound config
-	if v2.CFG.OutboundConfigs, e = internal.Gen_outbound(umap); nil != e {
+	return internal.Gen_outbound(umap);
+}
+
+// Makes URL of @umap, through outbound (to normalize it)
+func umap2url(umap internal.URLmap) (string, error) {
+	v, e := internal.Gen_outbound(umap);
+	if nil != e {
+		return "", e
+	}
+	url := internal.Gen_URL(&v[0]);
+	if nil == url {
+		return "", errors.New("Gen URL failed")
+	}
+	return url.String(), nil
+}
+
+// Initializes @v2.CFG.OutboundConfig by the provided proxy URL @url
+// It replaces the placeholder outbound of the template, see PlaceholderTag
+func (v2 *V2utils) Init_Outbound_byURL(url string) (error) {
+	outbounds, e := Gen_Outbound_byURL(url);
+	if nil != e {
 		return e
 	}
-	return nil
+	v2.set_outbounds(outbounds);
+	return v2.Check_Routing();
 }
 
 func (v2 V2utils) Apply_URL(url string) (error) {
@@ -36,21 +66,383 @@ func (v2 V2utils) Apply_URL(url string) (error) {
 
 // Makes json output of v2.CFG, on @w
 func (v2 V2utils) CFG_Out(w io.Writer, indention bool) (error) {
+	m, e := v2.cfg_map(false);
+	if nil != e {
+		return e
+	}
 	encoder := json.NewEncoder(w)
 	if indention {
 		encoder.SetIndent("", "    ")
 	}
-	return encoder.Encode(v2.CFG);
+	return encoder.Encode(m);
+}
+
+// Output formats of CFG_Out_Format
+var CFG_Out_Formats = []string{"json", "yaml", "toml"}
+
+// Makes @format output of @v2.CFG on @w, see CFG_Out_Formats
+// yaml and toml outputs are always indented
+func (v2 V2utils) CFG_Out_Format(w io.Writer, format string, indention bool) error {
+	var data []byte
+	switch (format) {
+	case "", "json":
+		return v2.CFG_Out(w, indention);
+	case "yaml", "yml":
+		m, e := v2.cfg_map(false);
+		if nil == e {
+			data, e = json.Marshal(m);
+		}
+		if nil == e {
+			data, e = yaml.JSONToYAML(data);
+		}
+		if nil != e {
+			return e
+		}
+		break;
+	case "toml":
+		m, e := v2.cfg_map(true);
+		var tree *toml.Tree
+		if nil == e {
+			tree, e = toml.TreeFromMap(m);
+		}
+		if nil == e {
+			data, e = tree.Marshal();
+		}
+		if nil != e {
+			return e
+		}
+		break;
+	default:
+		return errors.New("invalid output format: " + format)
+	}
+	_, e := w.Write(data);
+	return e
+}
+
+// Returns @v2.CFG as map, without null values
+// xray takes some null values as set (e.g. reality target), and
+// TOML has no null at all; @toml_numbers keeps numbers integer
+func (v2 V2utils) cfg_map(toml_numbers bool) (map[string]any, error) {
+	data, e := json.Marshal(v2.CFG);
+	if nil != e {
+		return nil, e
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var m map[string]any
+	if e = decoder.Decode(&m); nil != e {
+		return nil, e
+	}
+	return strip_null(m, toml_numbers).(map[string]any), nil
+}
+
+func strip_null(v any, toml_numbers bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		res := make(map[string]any)
+		for key, value := range v {
+			if nil != value {
+				res[key] = strip_null(value, toml_numbers)
+			}
+		}
+		return res
+	case []any:
+		res := make([]any, 0, len(v))
+		for _, value := range v {
+			if nil != value {
+				res = append(res, strip_null(value, toml_numbers))
+			}
+		}
+		return res
+	case json.Number:
+		if !toml_numbers {
+			return v
+		}
+		if i, e := v.Int64(); nil == e {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
+
+// Validation error of the config of proxy URL(s) Input
+type ValidationError struct {
+	Input string // the proxy URL(s)
+	Tag string   // tag of the invalid outbound, if any
+	Err error    // error of xray Build
+}
+
+func (e *ValidationError) Error() string {
+	if "" != e.Tag {
+		return fmt.Sprintf("outbound '%s' - %v", e.Tag, e.Err)
+	}
+	return e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Checks the output of @v2.CFG (see CFG_Out) by xray Build,
+// @input is its source URL(s)
+// Geo files are not needed, see stub_geo
+// Returns *ValidationError on failure
+func (v2 V2utils) Validate(input string) error {
+	// Build modifies the config (e.g. reality spiderX)
+	var out bytes.Buffer
+	if e := v2.CFG_Out(&out, false); nil != e {
+		return e
+	}
+	data, e := stub_geo(out.Bytes())
+	if nil != e {
+		return &ValidationError{Input: input, Err: e}
+	}
+	c, e := internal.Gen_main(string(data));
+	if nil != e {
+		return &ValidationError{Input: input, Err: e}
+	}
+	for _, ob := range c.OutboundConfigs {
+		if _, e = ob.Build(); nil != e {
+			return &ValidationError{Input: input, Tag: ob.Tag, Err: e}
+		}
+	}
+	if _, e = c.Build(); nil != e {
+		return &ValidationError{Input: input, Err: e}
+	}
+	return nil
+}
+
+// Stubs of geo files references
+const (
+	geo_stub_ip = "127.0.0.1/32"
+	geo_stub_domain = "full:geo.stub"
+)
+
+// Replaces geo files references (geoip:, geosite: and ext:) of
+// routing and dns sections of json config @data by stubs, so it
+// builds where the files are missing (e.g. convert on another host)
+func stub_geo(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var m map[string]any
+	if e := decoder.Decode(&m); nil != e {
+		return nil, e
+	}
+	for _, key := range []string{"routing", "dns"} {
+		if v, ok := m[key]; ok {
+			m[key] = stub_geo_value(v, false)
+		}
+	}
+	return json.Marshal(m)
+}
+
+// @ip:  @v is an ip list (e.g. `ip` of rules), otherwise domains
+func stub_geo_value(v any, ip bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		res := make(map[string]any)
+		for key, value := range v {
+			lower := strings.ToLower(key)
+			is_ip := strings.Contains(lower, "ip") || "source" == lower
+			if s, ok := stub_geo_value(key, false).(string); ok {
+				key = s // dns hosts
+			}
+			res[key] = stub_geo_value(value, is_ip)
+		}
+		return res
+	case []any:
+		res := make([]any, 0, len(v))
+		for _, value := range v {
+			res = append(res, stub_geo_value(value, ip))
+		}
+		return res
+	case string:
+		switch {
+		case strings.HasPrefix(v, "geoip:"), strings.HasPrefix(v, "ext-ip:"):
+			return geo_stub_ip
+		case strings.HasPrefix(v, "geosite:"), strings.HasPrefix(v, "ext-domain:"):
+			return geo_stub_domain
+		case strings.HasPrefix(v, "ext:"):
+			if ip {
+				return geo_stub_ip
+			}
+			return geo_stub_domain
+		}
+	}
+	return v
+}
+
+// Outbound protocols which are not proxy
+var Non_Proxy_Protocols = []string{
+	"freedom", "blackhole", "dns", "loopback",
+}
+
+// Returns proxy outbounds of @v2.CFG, their tag matching @tag_glob
+// Empty @tag_glob matches all, see path.Match for the syntax
+// Outbounds without remark get their tag as remark (the URL #name)
+func (v2 V2utils) Proxy_Outbounds(tag_glob string) ([]conf.OutboundDetourConfig, error) {
+	if nil == v2.CFG || 0 >= len(v2.CFG.OutboundConfigs) {
+		return nil, errors.New("Empty outbound configs")
+	}
+	var res []conf.OutboundDetourConfig
+	for _, ob := range v2.CFG.OutboundConfigs {
+		if slices.Contains(Non_Proxy_Protocols, strings.ToLower(ob.Protocol)) {
+			continue;
+		}
+		if "" != tag_glob {
+			if ok, e := path.Match(tag_glob, ob.Tag); nil != e {
+				return nil, e
+			} else if !ok {
+				continue;
+			}
+		}
+		if "" == internal.Get_remark(&ob) {
+			internal.Set_remark(&ob, ob.Tag); // ob is a copy
+		}
+		res = append(res, ob)
+	}
+	if 0 == len(res) {
+		return nil, errors.New("No proxy outbound was found")
+	}
+	return res, nil
 }
 
 // URL generator
-func (v2 V2utils) Convert_conf2url() (string, error) {
-	if 0 >= len(v2.CFG.OutboundConfigs) {
-		return "", errors.New("Empty outbound configs")
+// Generates URL of proxy outbounds of @v2.CFG, see Proxy_Outbounds
+// Unsupported outbounds are skipped with a warning
+func (v2 V2utils) Convert_conf2url(tag_glob string) ([]string, error) {
+	outbounds, e := v2.Proxy_Outbounds(tag_glob);
+	if nil != e {
+		return nil, e
+	}
+	var res []string
+	for i := range outbounds {
+		url := internal.Gen_URL(&outbounds[i]);
+		if nil == url {
+			log.Warnf("Converting outbound '%s' (%s) to URL failed\n",
+				outbounds[i].Tag, outbounds[i].Protocol);
+			continue;
+		}
+		res = append(res, url.String())
+	}
+	if 0 == len(res) {
+		return nil, errors.New("Gen URL failed")
+	}
+	return res, nil
+}
+
+// Returns remark (name) of the placeholder outbound of @v2.CFG,
+// or the first proxy outbound, when there is no placeholder
+// Templates may have direct, block, etc. before the proxy
+func (v2 V2utils) Remark() string {
+	if nil == v2.CFG {
+		return ""
+	}
+	var first *conf.OutboundDetourConfig
+	for i := range v2.CFG.OutboundConfigs {
+		ob := &v2.CFG.OutboundConfigs[i]
+		if slices.Contains(Non_Proxy_Protocols, strings.ToLower(ob.Protocol)) {
+			continue;
+		}
+		if PlaceholderTag == ob.Tag {
+			return internal.Get_remark(ob);
+		}
+		if nil == first {
+			first = ob
+		}
 	}
-	url := internal.Gen_URL(&v2.CFG.OutboundConfigs[0]);
+	if nil == first {
+		return ""
+	}
+	return internal.Get_remark(first);
+}
+
+// Collects proxies, to make a single output file
+// See ClashProxies and SingboxOutbounds
+type ProxyCollector interface {
+	Add_URL(url string) error
+	Add_Outbound(src *conf.OutboundDetourConfig) error
+	Len() int
+	Out(w io.Writer) error
+}
+
+// Output format of proxy_collector
+type proxy_format interface {
+	// Adds proxy of the normalized @umap, @unique makes its name unique
+	add(umap internal.URLmap, unique func(name string) string) error
+	len() int
+	out(w io.Writer) error
+}
+
+// ProxyCollector of output format @format
+// Parses, normalizes and dedupes names of proxies for @format
+type proxy_collector struct {
+	format proxy_format
+	names map[string]bool
+}
+
+func new_proxy_collector(format proxy_format) proxy_collector {
+	return proxy_collector{
+		format: format,
+		names: make(map[string]bool),
+	};
+}
+
+// Adds proxy URL @url
+func (c *proxy_collector) Add_URL(url string) error {
+	umap, e := internal.ParseURL(url);
+	if nil != e {
+		return e
+	}
+	// Gen_outbound also normalizes @umap
+	if _, e = internal.Gen_outbound(umap); nil != e {
+		return e
+	}
+	return c.format.add(umap, c.unique_name);
+}
+
+// Adds outbound @src
+func (c *proxy_collector) Add_Outbound(src *conf.OutboundDetourConfig) error {
+	url := internal.Gen_URL(src);
 	if nil == url {
-		return "", errors.New("Gen URL failed")
+		return errors.New("Gen URL failed")
 	}
-	return url.String(), nil
+	return c.Add_URL(url.String());
+}
+
+// Clash and sing-box need unique names
+func (c *proxy_collector) unique_name(name string) string {
+	res := name
+	for i := 2; c.names[res]; i += 1 {
+		res = name + " " + strconv.Itoa(i)
+	}
+	c.names[res] = true
+	return res
+}
+
+// Returns number of the added proxies
+func (c proxy_collector) Len() int {
+	return c.format.len()
+}
+
+// Makes output of the proxies, on @w
+func (c proxy_collector) Out(w io.Writer) error {
+	return c.format.out(w);
+}
+
+// Adds proxy outbounds of @v2.CFG to @dst, see Proxy_Outbounds
+// Unsupported outbounds are skipped with a warning
+func (v2 V2utils) Convert_conf2proxies(dst ProxyCollector, tag_glob string) error {
+	outbounds, e := v2.Proxy_Outbounds(tag_glob);
+	if nil != e {
+		return e
+	}
+	for i := range outbounds {
+		if e = dst.Add_Outbound(&outbounds[i]); nil != e {
+			log.Warnf("Converting outbound '%s' failed - %v\n", outbounds[i].Tag, e);
+		}
+	}
+	return nil
 }
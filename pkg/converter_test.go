@@ -0,0 +1,188 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"os"
+	"bytes"
+	"errors"
+	"testing"
+	"encoding/json"
+	"path/filepath"
+)
+
+const multi_outbound_sample = `{"outbounds": [
+	{"tag": "proxy-de", "protocol": "trojan",
+	 "settings": {"servers": [{"address": "1.2.3.4", "port": 443, "password": "p"}]}},
+	{"tag": "proxy-us", "protocol": "shadowsocks",
+	 "settings": {"servers": [{"address": "5.6.7.8", "port": 8388, "method": "aes-256-gcm", "password": "p"}]}},
+	{"tag": "backup", "protocol": "socks",
+	 "settings": {"remark": "My backup", "address": "9.9.9.9", "port": 1080}},
+	{"tag": "direct", "protocol": "freedom"},
+	{"tag": "block", "protocol": "blackhole"}
+]}`
+
+func TestConvert_conf2url(t *testing.T) {
+	v2 := V2utils{}
+	if e := v2.Apply_template_bystr(multi_outbound_sample); nil != e {
+		t.Fatalf("Apply_template_bystr failed: %v\n", e)
+	}
+	for glob, expected := range map[string][]string{
+		"": {
+			"trojan://p@1.2.3.4:443#proxy-de",
+			"ss://YWVzLTI1Ni1nY206cA==@5.6.7.8:8388#proxy-us",
+			"socks5://9.9.9.9:1080#My%20backup",
+		},
+		"proxy-*": {
+			"trojan://p@1.2.3.4:443#proxy-de",
+			"ss://YWVzLTI1Ni1nY206cA==@5.6.7.8:8388#proxy-us",
+		},
+		"backup": {"socks5://9.9.9.9:1080#My%20backup"},
+	} {
+		urls, e := v2.Convert_conf2url(glob)
+		if nil != e {
+			t.Fatalf("'%s': Convert_conf2url failed: %v\n", glob, e)
+		}
+		if len(urls) != len(expected) {
+			t.Fatalf("'%s': expected %d URLs, got %v\n", glob, len(expected), urls)
+		}
+		for i := range urls {
+			if urls[i] != expected[i] {
+				t.Fatalf("'%s': URL #%d: expected '%s', got '%s'\n", glob, i, expected[i], urls[i])
+			}
+		}
+	}
+
+	// The tag is not a remark
+	if r := v2.Remark(); "" != r {
+		t.Fatalf("CFG was modified, remark '%s'\n", r)
+	}
+	if _, e := v2.Convert_conf2url("direct"); nil == e {
+		t.Fatalf("freedom was converted\n")
+	}
+}
+
+// Raw json parts (e.g. routing rules) have no fixed key order
+func normal_json(t *testing.T, v any) string {
+	var m any
+	data, _ := json.Marshal(v)
+	if e := json.Unmarshal(data, &m); nil != e {
+		t.Fatalf("json failed: %v\n", e)
+	}
+	data, _ = json.Marshal(m)
+	return string(data)
+}
+
+func TestCFG_Out_Format(t *testing.T) {
+	urls := []string{
+		"vless://id@1.2.3.4:443?type=ws&path=/ws&security=tls&sni=x.com&alpn=h2,http/1.1#a",
+		"trojan://pass@1.2.3.4:443?security=tls&sni=x.com#b",
+	}
+	src := V2utils{}
+	src.Apply_template_bystr(test_template)
+	if e := src.Init_Outbounds_byURLs(urls, "leastPing"); nil != e {
+		t.Fatalf("Init_Outbounds_byURLs failed: %v\n", e)
+	}
+	expected := normal_json(t, src.CFG)
+
+	dir := t.TempDir()
+	for _, format := range CFG_Out_Formats {
+		var out bytes.Buffer
+		if e := src.CFG_Out_Format(&out, format, true); nil != e {
+			t.Fatalf("%s: CFG_Out_Format failed: %v\n", format, e)
+		}
+		path := filepath.Join(dir, "config." + format)
+		os.WriteFile(path, out.Bytes(), 0644)
+
+		v2 := V2utils{}
+		if e := v2.Apply_template(path); nil != e {
+			t.Fatalf("%s: Apply_template of output failed: %v\n%s", format, e, out.String())
+		}
+		if res := normal_json(t, v2.CFG); expected != res {
+			t.Fatalf("%s: round trip mismatch:\n%s\n%s\n", format, expected, res)
+		}
+		if _, e := v2.CFG.Build(); nil != e {
+			t.Fatalf("%s: Build failed: %v\n", format, e)
+		}
+	}
+	if e := src.CFG_Out_Format(&bytes.Buffer{}, "xml", true); nil == e {
+		t.Fatalf("invalid format was accepted\n")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	v2 := V2utils{}
+	reality := "vless://id@1.2.3.4:443?type=tcp&security=reality&sni=x.com&fp=chrome" +
+		"&pbk=7fp5-ryBDDWmfpVUy-6WL8ipfNKJCnSSVaSX5-7JzBA&sid=ab"
+	v2.Apply_template_bystr(DEF_Run_Template)
+	v2.Init_Outbound_byURL(reality)
+	before := normal_json(t, v2.CFG)
+	if e := v2.Validate(reality); nil != e {
+		t.Fatalf("valid config was rejected: %v\n", e)
+	}
+	if before != normal_json(t, v2.CFG) {
+		t.Fatalf("Validate modified the config\n")
+	}
+
+	for _, url := range []string{
+		"vless://id@1.2.3.4:443?type=tcp&security=reality&sni=x.com",
+		"vless://id@1.2.3.4:443?type=xhttp&mode=bad-mode",
+	} {
+		v2.Apply_template_bystr(DEF_Run_Template)
+		if e := v2.Init_Outbound_byURL(url); nil != e {
+			t.Fatalf("Init_Outbound_byURL failed: %v\n", e)
+		}
+		e := v2.Validate(url)
+		var ve *ValidationError
+		if !errors.As(e, &ve) {
+			t.Fatalf("%s: expected ValidationError, got: %v\n", url, e)
+		}
+		if url != ve.Input || "proxy" != ve.Tag || nil == errors.Unwrap(e) {
+			t.Fatalf("%s: unexpected error fields: %#v\n", url, ve)
+		}
+	}
+
+	// Invalid outbounds are skipped by the balancer
+	v2.Apply_template_bystr(DEF_Run_Template)
+	urls := []string{sub_links[0], "vless://id@1.2.3.4:443?type=tcp&security=reality&sni=x.com"}
+	if e := v2.Init_Outbounds_byURLs(urls, "random"); nil != e {
+		t.Fatalf("Init_Outbounds_byURLs failed: %v\n", e)
+	}
+	if 1 != len(v2.CFG.OutboundConfigs) {
+		t.Fatalf("invalid outbound was not skipped\n")
+	}
+	if e := v2.Validate(""); nil != e {
+		t.Fatalf("balancer config was rejected: %v\n", e)
+	}
+
+	// Geo files are not needed
+	t.Setenv("XRAY_LOCATION_ASSET", t.TempDir())
+	v2.Apply_template_bystr(`{"routing": {"rules": [
+		{"outboundTag": "direct", "ip": ["geoip:private", "geoip:!ir", "ext:ip.dat:x"]},
+		{"outboundTag": "direct", "domain": ["geosite:private", "ext:site.dat:x"]}
+	]}, "dns": {
+		"hosts": {"geosite:ads": "127.0.0.1"},
+		"servers": [{"address": "1.1.1.1", "domains": ["geosite:ir"], "expectIPs": ["geoip:ir"]}]
+	}, "outbounds": [{"tag": "direct", "protocol": "freedom"}]}`)
+	if e := v2.Init_Outbound_byURL(sub_links[0]); nil != e {
+		t.Fatalf("Init_Outbound_byURL failed: %v\n", e)
+	}
+	if e := v2.Validate(sub_links[0]); nil != e {
+		t.Fatalf("config with geo rules was rejected: %v\n", e)
+	}
+
+	// The rest of config is also checked
+	for name, routing := range map[string]string{
+		"bad rule": `{"rules": [{"outboundTag": "direct", "ip": ["geoip:private", "not-an-ip"]}]}`,
+		"bad balancer": `{"balancers": [{"tag": "b", "selector": ["direct"], "strategy": {"type": "bogus"}}]}`,
+	} {
+		v2.Apply_template_bystr(`{"routing": ` + routing + `,
+			"outbounds": [{"tag": "direct", "protocol": "freedom"}]}`)
+		if e := v2.Init_Outbound_byURL(sub_links[0]); nil != e {
+			t.Fatalf("Init_Outbound_byURL failed: %v\n", e)
+		}
+		var ve *ValidationError
+		if e := v2.Validate(sub_links[0]); !errors.As(e, &ve) || "" != ve.Tag {
+			t.Fatalf("%s: expected ValidationError of the config, got: %v\n", name, e)
+		}
+	}
+}
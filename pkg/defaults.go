@@ -1,6 +1,7 @@
 // defaults.go
 //go:build !debug
 // +build !debug
+
 package pkg 
 
 const (
@@ -19,4 +20,13 @@ const (
          {
               "log": {"loglevel": "none"}
          }`
+
+	// Default template for server inbounds (serve command)
+	DEF_Server_Template =`
+         {
+              "log": {"loglevel": "warning"},
+              "outbounds": [
+                  {"protocol": "freedom", "tag": "direct"}
+              ]
+         }`
 )
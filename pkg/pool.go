@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"sync"
+
+	conf "github.com/xtls/xray-core/infra/conf"
+)
+
+const (
+	TestJob_URL int = iota
+	TestJob_CFG
+	TestJob_Chain
+) // test job types
+
+type TestJob struct {
+	Type int                // TestJob_xxx
+	Input string            // proxy URL, config file path or chain of URLs
+
+	// Filled by the pool
+	Err error
+	Result *TestResult
+	CFG *conf.Config        // the tested config (e.g. to make json output)
+
+	index int
+};
+
+// Runs tests in parallel, using @Workers independent V2utils instances
+// Chains and configs with domain strategies run alone (see xray_mutex)
+type TestPool struct {
+	Workers int
+	Ordered bool            // report results in the same order as inputs
+	Tester ConnectivityTester_I
+};
+
+func NewTestPool(workers int, tester ConnectivityTester_I) *TestPool {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &TestPool{
+		Workers: workers,
+		Ordered: true,
+		Tester: tester,
+	};
+}
+
+func (job *TestJob) do(v2 *V2utils, tester ConnectivityTester_I) {
+	switch (job.Type) {
+	case TestJob_URL:
+		job.Err, job.Result = v2.Test_URL(job.Input, tester);
+		break;
+	case TestJob_CFG:
+		job.Err, job.Result = v2.Test_CFG(job.Input, tester);
+		break;
+	case TestJob_Chain:
+		job.Err, job.Result = v2.Test_Chain(job.Input, tester);
+		break;
+	}
+	job.CFG = v2.CFG
+	v2.UnsetTemplate()
+}
+
+// Runs the pool (blocking)
+// @next:  is called to get the next job, returns nil at the end of inputs
+//         it runs on a dedicated goroutine, not the caller's; when the
+//         pool is stopped, it's not called anymore, but the pool does not
+//         wait for a blocked call (e.g. reading stdin) to return
+// @done:  is called on the caller's goroutine for each finished job
+//         in the input order if @p.Ordered is set, otherwise as soon as done
+//         returning false stops the pool, remaining results are discarded
+func (p *TestPool) Run(next func() *TestJob, done func(*TestJob) bool) {
+	var wg sync.WaitGroup
+	jobs := make(chan *TestJob)
+	results := make(chan *TestJob, p.Workers)
+	stop := make(chan struct{})
+
+	for i := 0; i < p.Workers; i += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v2 := &V2utils{concurrent: p.Workers > 1}
+			for {
+				select {
+				case job, ok := <-jobs:
+					if !ok {
+						return
+					}
+					job.do(v2, p.Tester);
+					results <- job
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		for idx := 0;; idx += 1 {
+			job := next()
+			if nil == job {
+				break;
+			}
+			job.index = idx
+			select {
+			case jobs <- job:
+			case <-stop:
+				return
+			}
+		}
+		close(jobs)
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Jobs finished before their predecessors (ordered mode)
+	pending := make(map[int]*TestJob)
+	n := 0
+	stopped := false
+	for job := range results {
+		if stopped {
+			continue; // draining
+		}
+		if ! p.Ordered {
+			if ! done(job) {
+				stopped = true
+				close(stop)
+			}
+			continue;
+		}
+		pending[job.index] = job
+		for j, ok := pending[n]; ok && !stopped; j, ok = pending[n] {
+			delete(pending, n)
+			if ! done(j) {
+				stopped = true
+				close(stop)
+			}
+			n += 1
+		}
+	}
+}
@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+//go:build race
+
+package pkg
+
+import (
+	"io"
+	"testing"
+	"sync/atomic"
+	"net/http"
+	"net/http/httptest"
+)
+
+// Workers with real xray-core instances, through URLs and chains
+// Only by `go test -race`, as xray-core has global states
+func TestPool_Race(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+	var count atomic.Int32
+	proxy := connect_proxy(&count)
+	defer proxy.Close()
+	hop := "http://" + proxy.Listener.Addr().String()
+
+	i := 0
+	next := func() *TestJob {
+		if i >= 8 {
+			return nil
+		}
+		i += 1
+		if 0 == i % 2 {
+			return &TestJob{Type: TestJob_Chain, Input: hop + "," + hop}
+		}
+		return &TestJob{Type: TestJob_URL, Input: hop}
+	}
+	n := 0
+	pool := NewTestPool(4, &Simple_Contester{endpoints: []string{srv.URL}})
+	pool.Run(next, func(job *TestJob) bool {
+		if nil != job.Err {
+			t.Errorf("'%s' failed: %v\n", job.Input, job.Err)
+		}
+		n += 1
+		return true
+	})
+	if 8 != n {
+		t.Fatalf("expected 8 results, got %d\n", n)
+	}
+	// A chain tunnels twice
+	if c := count.Load(); 12 != c {
+		t.Fatalf("expected 12 tunnels, got %d\n", c)
+	}
+}
@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"io"
+	"fmt"
+	"time"
+	"sync"
+	"errors"
+	"testing"
+	"net/http"
+	"sync/atomic"
+	"encoding/json"
+	"net/http/httptest"
+
+	"github.com/siamak-amo/v2utils/internal"
+)
+
+// Fails on odd ports, and the lower ports take longer
+type Dummy_Contester struct{}
+
+func (tester Dummy_Contester) Test(v2 *V2utils) (error, *TestResult) {
+	var vless internal.VLessVnext
+	if e := json.Unmarshal(*v2.CFG.OutboundConfigs[0].Settings, &vless); nil != e {
+		return e, nil
+	}
+	p := vless.Vnext[0].Port
+	time.Sleep(time.Duration(20 - p) * 10 * time.Millisecond)
+	if 1 == p % 2 {
+		return errors.New("odd port"), nil
+	}
+	return nil, &TestResult{Duration: int64(p)}
+}
+
+func pool_inputs(n int) func() *TestJob {
+	i := 0
+	return func() *TestJob {
+		if i >= n {
+			return nil
+		}
+		i += 1
+		return &TestJob{
+			Type: TestJob_URL,
+			Input: fmt.Sprintf("vless://id@127.0.0.1:%d?type=tcp", i),
+		}
+	}
+}
+
+func TestPool_Ordered(t *testing.T) {
+	var res []*TestJob
+	pool := NewTestPool(4, Dummy_Contester{})
+	pool.Run(pool_inputs(8), func(job *TestJob) bool {
+		res = append(res, job)
+		return true
+	})
+
+	if 8 != len(res) {
+		t.Fatalf("expected 8 results, got %d\n", len(res))
+	}
+	for i, job := range res {
+		expected := fmt.Sprintf("vless://id@127.0.0.1:%d?type=tcp", i+1)
+		if job.Input != expected {
+			t.Fatalf("result #%d: expected '%s', got '%s'\n", i, expected, job.Input)
+		}
+		if (nil == job.Err) != (0 == (i+1) % 2) {
+			t.Fatalf("result #%d: unexpected error: %v\n", i, job.Err)
+		}
+		if nil == job.Err && nil == job.CFG {
+			t.Fatalf("result #%d: missing config\n", i)
+		}
+	}
+}
+
+func TestPool_Unordered(t *testing.T) {
+	count := 0
+	pool := NewTestPool(8, Dummy_Contester{})
+	pool.Ordered = false
+	pool.Run(pool_inputs(8), func(job *TestJob) bool {
+		count += 1
+		return true
+	})
+	if 8 != count {
+		t.Fatalf("expected 8 results, got %d\n", count)
+	}
+}
+
+func TestPool_Stop(t *testing.T) {
+	count := 0
+	pool := NewTestPool(2, Dummy_Contester{})
+	pool.Run(pool_inputs(16), func(job *TestJob) bool {
+		count += 1
+		return count < 3
+	})
+	if 3 != count {
+		t.Fatalf("expected to stop after 3 results, got %d\n", count)
+	}
+}
+
+// Stopping must not wait for a blocked @next (e.g. reading stdin)
+func TestPool_Stop_Blocked(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	inputs := pool_inputs(2)
+	next := func() *TestJob {
+		if job := inputs(); nil != job {
+			return job
+		}
+		<-block
+		return nil
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		pool := NewTestPool(2, Dummy_Contester{})
+		pool.Run(next, func(job *TestJob) bool {
+			return false
+		})
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the pool did not stop, while next was blocked\n")
+	}
+}
+
+// Instances of workers test at the same time
+func TestPool_Parallel(t *testing.T) {
+	var inflight atomic.Int32
+	var once sync.Once
+	all := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer inflight.Add(-1)
+		if 4 == inflight.Add(1) {
+			once.Do(func() { close(all) })
+		}
+		select {
+		case <-all:
+		case <-time.After(3 * time.Second):
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+	var count atomic.Int32
+	proxy := connect_proxy(&count)
+	defer proxy.Close()
+
+	i := 0
+	next := func() *TestJob {
+		if i >= 4 {
+			return nil
+		}
+		i += 1
+		return &TestJob{Type: TestJob_URL, Input: "http://" + proxy.Listener.Addr().String()}
+	}
+	pool := NewTestPool(4, &Simple_Contester{endpoints: []string{srv.URL}})
+	pool.Run(next, func(job *TestJob) bool {
+		if nil != job.Err {
+			t.Errorf("'%s' failed: %v\n", job.Input, job.Err)
+		}
+		return true
+	})
+	select {
+	case <-all:
+	default:
+		t.Fatalf("tests did not run in parallel\n")
+	}
+}
@@ -3,14 +3,42 @@ package pkg
 
 import (
 	"os"
+	"sync"
+	"errors"
+	"context"
 	"runtime"
+	"strings"
 	"syscall"
 	"os/signal"
 
 	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/infra/conf"
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/transport"
+	"github.com/xtls/xray-core/common/net/cnc"
+	"github.com/xtls/xray-core/transport/pipe"
+	"github.com/xtls/xray-core/features/routing"
+	xnet "github.com/xtls/xray-core/common/net"
 	_ "github.com/xtls/xray-core/app/proxyman/inbound"
 )
 
+// xray-core keeps states of the last created instance globally
+// (the dns client and outbound manager of the system dialer),
+// so creating and closing instances is locked
+// Concurrent instances that use these states (see uses_system_dialer)
+// hold the lock until Kill_Xray, others run at the same time
+var xray_mutex sync.Mutex
+
+// In-flight dials of a running instance, see CustomDial
+// Kill_Xray cancels and waits for them, as they use the
+// global states of xray-core until they return
+type xray_dials struct {
+	sync.Mutex
+	sync.WaitGroup
+	ctx context.Context
+	cancel context.CancelFunc
+}
+
 // Runs xray-core instance (non-blocking)
 func (v2 *V2utils) Run_Xray() error {
 	var err error
@@ -21,18 +49,51 @@ func (v2 *V2utils) Run_Xray() error {
 		return err
 	}
 
+	exclusive := v2.concurrent && uses_system_dialer(v2.CFG)
+	use_phase_dialer()
+	xray_mutex.Lock()
 	if v2.Xray_instance, err = core.New(cf); nil != err {
+		xray_mutex.Unlock()
 		return err
 	}
 	// Cleanup sh** we have done so far to make the config
 	runtime.GC()
 
 	if err = v2.Xray_instance.Start(); nil != err {
+		v2.Xray_instance.Close()
+		v2.Xray_instance = nil
+		xray_mutex.Unlock()
 		return err
 	}
+	v2.dials = &xray_dials{}
+	v2.dials.ctx, v2.dials.cancel = context.WithCancel(context.Background())
+	if v2.exclusive = exclusive; !exclusive {
+		xray_mutex.Unlock()
+	}
 	return nil
 }
 
+// Checks outbounds of @cfg use the system dialer states of xray-core,
+// which are dialerProxy (e.g. chains) and domain strategies
+func uses_system_dialer(cfg *conf.Config) bool {
+	has_strategy := func(s string) bool {
+		return "" != s && !strings.EqualFold(s, "AsIs")
+	}
+	for _, ob := range cfg.OutboundConfigs {
+		if has_strategy(ob.TargetStrategy) {
+			return true
+		}
+		if nil == ob.StreamSetting || nil == ob.StreamSetting.SocketSettings {
+			continue;
+		}
+		sockopt := ob.StreamSetting.SocketSettings
+		if "" != sockopt.DialerProxy || has_strategy(sockopt.DomainStrategy) {
+			return true
+		}
+	}
+	return false
+}
+
 // Run_Xray (blocking)
 func (v2 *V2utils) Exec_Xray() error {
 	if e := v2.Run_Xray(); nil != e {
@@ -47,11 +108,59 @@ func (v2 *V2utils) Exec_Xray() error {
 }
 
 // Do NOT use v2.Xray_instance after this call
-func (v2 V2utils) Kill_Xray() {
-	if nil != v2.Xray_instance {
-		if v2.Xray_instance.IsRunning() {
-			v2.Xray_instance.Close()
-			v2.Xray_instance = nil
-		}
+func (v2 *V2utils) Kill_Xray() {
+	if nil == v2.Xray_instance || nil == v2.dials {
+		return
+	}
+	v2.dials.Lock()
+	v2.dials.cancel()
+	v2.dials.Unlock()
+	v2.dials.Wait()
+
+	if !v2.exclusive {
+		xray_mutex.Lock()
+	}
+	v2.Xray_instance.Close()
+	v2.Xray_instance, v2.dials = nil, nil
+	xray_mutex.Unlock()
+}
+
+// Like core.Dial, but the dispatch runs on a goroutine of @d,
+// so it can be canceled and waited for
+func (d *xray_dials) dial(ctx context.Context, v *core.Instance, dst xnet.Destination) (xnet.Conn, error) {
+	dispatcher, ok := v.GetFeature(routing.DispatcherType()).(routing.Dispatcher)
+	if !ok {
+		return nil, errors.New("routing.Dispatcher is not registered in xray-core")
+	}
+	d.Lock()
+	defer d.Unlock()
+	if nil != d.ctx.Err() {
+		return nil, errors.New("xray-core is killed")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	up_r, up_w := pipe.New(pipe.OptionsFromContext(ctx)...)
+	down_r, down_w := pipe.New(pipe.OptionsFromContext(ctx)...)
+	// Outbounds may not watch the context while copying
+	stop := context.AfterFunc(d.ctx, func() {
+		cancel()
+		common.Interrupt(up_r)
+		common.Interrupt(down_w)
+	})
+
+	d.Add(1)
+	go func() {
+		defer d.Done()
+		defer stop()
+		defer cancel()
+		dispatcher.DispatchLink(ctx, dst, &transport.Link{Reader: up_r, Writer: down_w})
+	}()
+
+	var reader_opt cnc.ConnectionOption
+	if xnet.Network_TCP == dst.Network {
+		reader_opt = cnc.ConnectionOutputMulti(down_r)
+	} else {
+		reader_opt = cnc.ConnectionOutputMultiUDP(down_r)
 	}
+	return cnc.NewConnection(cnc.ConnectionInputMulti(up_w), reader_opt), nil
 }
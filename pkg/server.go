@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/siamak-amo/v2utils/internal"
+	log "github.com/siamak-amo/v2utils/log"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// Server side options: listen address, tls certificate files,
+// reality private key and target
+type ServerOptions = internal.ServerOptions
+
+// Client side options of server configs: public host, sni and
+// fingerprint, see Inbound_Clients
+type ClientOptions = internal.ClientOptions
+
+// Generates server inbound of the proxy URL @url,
+// which accepts the clients of @url
+func Gen_Inbound_byURL(url string, opts ServerOptions) (*conf.InboundDetourConfig, error) {
+	umap, e := internal.ParseURL(url);
+	if nil != e {
+		return nil, e
+	}
+	return internal.Gen_server_inbound(umap, opts);
+}
+
+// Initializes @v2.CFG.InboundConfigs by the server inbound of @url
+// Inbounds of the template are kept, the one with the same tag
+// (`inbound`) is replaced
+func (v2 *V2utils) Init_Inbound_byURL(url string, opts ServerOptions) error {
+	inbound, e := Gen_Inbound_byURL(url, opts);
+	if nil != e {
+		return e
+	}
+	v2.Set_Inbound(inbound);
+	return nil
+}
+
+// Adds @inbound to inbounds of the template, in place of
+// the one with the same tag (if any)
+func (v2 *V2utils) Set_Inbound(inbound *conf.InboundDetourConfig) {
+	var res []conf.InboundDetourConfig
+	for _, ib := range v2.template_inbounds {
+		if inbound.Tag != ib.Tag {
+			res = append(res, ib)
+		}
+	}
+	v2.CFG.InboundConfigs = append(res, *inbound)
+}
+
+// Server kinds of Gen_Server: vless-reality, vless-ws, vmess-ws,
+// trojan-tls and ss (shadowsocks 2022)
+var Server_Kinds = internal.Server_Kinds
+
+// Default reality sni (and target) of Gen_Server
+var DEF_Reality_SNI = "www.microsoft.com"
+
+// Generates a new server of @kind (see Server_Kinds) with random
+// credentials, at the public address @host:@port
+// @sni is the server name of tls (default: @host) and reality
+// (default: DEF_Reality_SNI), the reality private key is generated
+// Returns the client URL and the server inbound, which accepts it
+func Gen_Server(kind, host string, port int, sni string, opts ServerOptions) (string, *conf.InboundDetourConfig, error) {
+	if "" == sni && "vless-reality" == kind {
+		sni = DEF_Reality_SNI
+	}
+	umap, private, e := internal.Gen_server_URLmap(kind, host, port, sni);
+	if nil != e {
+		return "", nil, e
+	}
+	opts.RealityPrivateKey = private
+	inbound, e := internal.Gen_server_inbound(umap, opts);
+	if nil != e {
+		return "", nil, e
+	}
+	url, e := umap2url(umap);
+	if nil != e {
+		return "", nil, e
+	}
+	return url, inbound, nil
+}
+
+// Returns client outbounds of server inbounds of @v2.CFG, one per
+// client, see internal.Gen_client_outbounds
+// @opts.Host is the public address of the server, if empty, the listen
+// address of inbounds is used (unless it's 0.0.0.0 or ::)
+// Inbounds without client URL (e.g. socks) are skipped
+func (v2 V2utils) Inbound_Clients(opts ClientOptions) ([]conf.OutboundDetourConfig, error) {
+	if nil == v2.CFG {
+		return nil, errors.New("No config")
+	}
+	var res []conf.OutboundDetourConfig
+	for i, ib := range v2.CFG.InboundConfigs {
+		if !slices.Contains(internal.Server_Protocols, ib.Protocol) {
+			continue;
+		}
+		addr := opts
+		if "" == addr.Host {
+			if nil == ib.ListenOn || (ib.ListenOn.Address.Family().IsIP() &&
+				ib.ListenOn.Address.IP().IsUnspecified()) {
+				log.Warnf("Inbound #%d (%s) needs a public host\n", i + 1, ib.Tag);
+				continue;
+			}
+			addr.Host = ib.ListenOn.Address.String()
+		}
+		clients, e := internal.Gen_client_outbounds(&v2.CFG.InboundConfigs[i], addr);
+		if nil != e {
+			log.Warnf("Inbound #%d (%s) was skipped - %v\n", i + 1, ib.Tag, e);
+			continue;
+		}
+		res = append(res, clients...)
+	}
+	if 0 == len(res) {
+		return nil, errors.New("No server inbound was found")
+	}
+	return res, nil
+}
+
+// URL generator of server configs
+// Generates client URLs of server inbounds of @v2.CFG, see Inbound_Clients
+func (v2 V2utils) Convert_inbounds2url(opts ClientOptions) ([]string, error) {
+	outbounds, e := v2.Inbound_Clients(opts);
+	if nil != e {
+		return nil, e
+	}
+	var res []string
+	for i := range outbounds {
+		url := internal.Gen_URL(&outbounds[i]);
+		if nil == url {
+			log.Warnf("Converting client '%s' (%s) to URL failed\n",
+				internal.Get_remark(&outbounds[i]), outbounds[i].Protocol);
+			continue;
+		}
+		res = append(res, url.String())
+	}
+	if 0 == len(res) {
+		return nil, errors.New("Gen URL failed")
+	}
+	return res, nil
+}
+
+// Adds clients of server inbounds of @v2.CFG to @dst, see Inbound_Clients
+func (v2 V2utils) Convert_inbounds2proxies(dst ProxyCollector, opts ClientOptions) error {
+	outbounds, e := v2.Inbound_Clients(opts);
+	if nil != e {
+		return e
+	}
+	for i := range outbounds {
+		if e = dst.Add_Outbound(&outbounds[i]); nil != e {
+			log.Warnf("Converting client '%s' failed - %v\n", internal.Get_remark(&outbounds[i]), e);
+		}
+	}
+	return nil
+}
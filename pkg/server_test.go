@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"encoding/base64"
+)
+
+func free_port(t *testing.T) int {
+	l, e := net.Listen("tcp", "127.0.0.1:0")
+	if nil != e {
+		t.Fatalf("Listen failed: %v\n", e)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// Clients of URLs must work through the server of the same URLs
+func TestInit_Inbound_byURL(t *testing.T) {
+	srv := sub_server(t)
+	defer srv.Close()
+
+	vmess := `{"v": "2", "add": "127.0.0.1", "port": "%d", "net": "grpc", "path": "x",` +
+		`"id": "5783a3e7-e373-51cd-8642-c83782b807c5"}`
+	for _, gen_url := range []func(port int) string{
+		func(port int) string {
+			return fmt.Sprintf("vless://5783a3e7-e373-51cd-8642-c83782b807c5@127.0.0.1:%d?type=ws&path=/ws#a", port)
+		},
+		func(port int) string {
+			return "vmess://" + base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf(vmess, port)))
+		},
+		func(port int) string {
+			return fmt.Sprintf("trojan://pass@127.0.0.1:%d?type=httpupgrade&path=/up", port)
+		},
+		func(port int) string {
+			return fmt.Sprintf("ss://YWVzLTI1Ni1nY206cGFzcw==@127.0.0.1:%d", port)
+		},
+	} {
+		url := gen_url(free_port(t))
+		server := &V2utils{}
+		server.Apply_template_bystr(DEF_Server_Template)
+		opts := ServerOptions{Listen: "127.0.0.1"}
+		if e := server.Init_Inbound_byURL(url, opts); nil != e {
+			t.Fatalf("%s: Init_Inbound_byURL failed: %v\n", url, e)
+		}
+		// Applying again replaces the inbound
+		server.Init_Inbound_byURL(url, opts)
+		if 1 != len(server.CFG.InboundConfigs) {
+			t.Fatalf("%s: expected 1 inbound, got %d\n", url, len(server.CFG.InboundConfigs))
+		}
+		if e := server.Run_Xray(); nil != e {
+			t.Fatalf("%s: Run_Xray failed: %v\n", url, e)
+		}
+
+		client := &V2utils{}
+		e, _ := client.Test_URL(url, URL_Contester{srv.URL + "/sub"})
+		server.Kill_Xray()
+		if nil != e {
+			t.Fatalf("%s: client failed through the server: %v\n", url, e)
+		}
+	}
+}
+
+// Client URLs of a server config must work through that server
+func TestConvert_inbounds2url(t *testing.T) {
+	srv := sub_server(t)
+	defer srv.Close()
+
+	port := free_port(t)
+	url := fmt.Sprintf("trojan://pass@0.0.0.0:%d?type=ws&path=/ws#alice", port)
+	server := &V2utils{}
+	server.Apply_template_bystr(DEF_Server_Template)
+	if e := server.Init_Inbound_byURL(url, ServerOptions{}); nil != e {
+		t.Fatalf("Init_Inbound_byURL failed: %v\n", e)
+	}
+	if _, e := server.Convert_inbounds2url(ClientOptions{}); nil == e {
+		t.Fatalf("0.0.0.0 was used as the public host\n")
+	}
+	urls, e := server.Convert_inbounds2url(ClientOptions{Host: "127.0.0.1"})
+	if nil != e {
+		t.Fatalf("Convert_inbounds2url failed: %v\n", e)
+	}
+	if 1 != len(urls) {
+		t.Fatalf("expected 1 URL, got %v\n", urls)
+	}
+	expected := fmt.Sprintf("trojan://pass@127.0.0.1:%d?path=%%2Fws&security=none&type=ws#alice", port)
+	if expected != urls[0] {
+		t.Fatalf("expected %s, got %s\n", expected, urls[0])
+	}
+
+	if e := server.Run_Xray(); nil != e {
+		t.Fatalf("Run_Xray failed: %v\n", e)
+	}
+	defer server.Kill_Xray()
+	client := &V2utils{}
+	if e, _ := client.Test_URL(urls[0], URL_Contester{srv.URL + "/sub"}); nil != e {
+		t.Fatalf("client failed through the server: %v\n", e)
+	}
+}
+
+// Generated servers must accept their URL
+func TestGen_Server(t *testing.T) {
+	srv := sub_server(t)
+	defer srv.Close()
+
+	for _, kind := range []string{"vless-ws", "vmess-ws", "ss"} {
+		url, inbound, e := Gen_Server(kind, "127.0.0.1", free_port(t), "", ServerOptions{Listen: "127.0.0.1"})
+		if nil != e {
+			t.Fatalf("%s: Gen_Server failed: %v\n", kind, e)
+		}
+		server := &V2utils{}
+		server.Apply_template_bystr(DEF_Server_Template)
+		server.Set_Inbound(inbound)
+		if e := server.Run_Xray(); nil != e {
+			t.Fatalf("%s: Run_Xray failed: %v\n", kind, e)
+		}
+		client := &V2utils{}
+		e, _ = client.Test_URL(url, URL_Contester{srv.URL + "/sub"})
+		server.Kill_Xray()
+		if nil != e {
+			t.Fatalf("%s: client failed through the server: %v\n", kind, e)
+		}
+	}
+	if _, _, e := Gen_Server("trojan-tls", "1.2.3.4", 443, "", ServerOptions{}); nil == e {
+		t.Fatalf("tls without certificate was accepted\n")
+	}
+}
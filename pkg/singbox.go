@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"io"
+	"slices"
+	"encoding/json"
+
+	"github.com/siamak-amo/v2utils/internal"
+	log "github.com/siamak-amo/v2utils/log"
+)
+
+// sing-box outbounds (and wireguard endpoints)
+// Use Add_xxx functions to add proxies, then Out to make json
+type SingboxOutbounds struct {
+	proxy_collector
+}
+
+type singbox_format struct {
+	cfg internal.SingboxConfig
+}
+
+// sing-box outbound types which are not proxy
+var singbox_non_proxy = []string{
+	"direct", "block", "dns", "selector", "urltest",
+}
+
+func NewSingboxOutbounds() *SingboxOutbounds {
+	return &SingboxOutbounds{
+		new_proxy_collector(&singbox_format{
+			cfg: internal.SingboxConfig{Outbounds: []internal.SingboxOutbound{}},
+		}),
+	};
+}
+
+func (s *singbox_format) add(umap internal.URLmap, unique func(string) string) error {
+	if "wireguard" == umap[internal.Protocol] || "wg" == umap[internal.Protocol] {
+		endpoint, e := internal.Gen_singbox_endpoint(umap);
+		if nil != e {
+			return e
+		}
+		endpoint.Tag = unique(endpoint.Tag)
+		s.cfg.Endpoints = append(s.cfg.Endpoints, *endpoint)
+		return nil
+	}
+	outbound, e := internal.Gen_singbox_outbound(umap);
+	if nil != e {
+		return e
+	}
+	outbound.Tag = unique(outbound.Tag)
+	s.cfg.Outbounds = append(s.cfg.Outbounds, *outbound)
+	return nil
+}
+
+func (s singbox_format) len() int {
+	return len(s.cfg.Outbounds) + len(s.cfg.Endpoints)
+}
+
+func (s singbox_format) out(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "    ")
+	return encoder.Encode(s.cfg);
+}
+
+// Converts outbounds of sing-box json config @data to URL
+// Unsupported outbounds are skipped with a warning
+func Parse_Singbox(data []byte) ([]string, error) {
+	var cfg internal.SingboxConfig
+	if e := json.Unmarshal(data, &cfg); nil != e {
+		return nil, e
+	}
+	var res []string
+	for i := range cfg.Outbounds {
+		src := &cfg.Outbounds[i]
+		if slices.Contains(singbox_non_proxy, src.Type) {
+			continue;
+		}
+		umap, e := internal.Parse_singbox_outbound(src);
+		var url string
+		if nil == e {
+			url, e = umap2url(umap);
+		}
+		if nil == e {
+			res = append(res, url)
+			continue;
+		}
+		log.Warnf("sing-box outbound '%s' was ignored - %v\n", src.Tag, e);
+	}
+	for i := range cfg.Endpoints {
+		src := &cfg.Endpoints[i]
+		umap, e := internal.Parse_singbox_endpoint(src);
+		var url string
+		if nil == e {
+			url, e = umap2url(umap);
+		}
+		if nil == e {
+			res = append(res, url)
+			continue;
+		}
+		log.Warnf("sing-box endpoint '%s' was ignored - %v\n", src.Tag, e);
+	}
+	return res, nil
+}
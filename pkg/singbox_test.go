@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const singbox_sample = `{
+  "log": {"level": "warn"},
+  "outbounds": [
+    {
+      "type": "vless", "tag": "vless ws",
+      "server": "1.2.3.4", "server_port": 443,
+      "uuid": "a6a4d1c4-6a2e-4a1e-9a4b-62a3b1a6d8f1",
+      "tls": {"enabled": true, "server_name": "x.com"},
+      "transport": {"type": "ws", "path": "/ws", "headers": {"Host": "x.com"}}
+    },
+    {"type": "shadowsocks", "tag": "ss", "server": "1.2.3.4", "server_port": 8388,
+     "method": "aes-256-gcm", "password": "pass"},
+    {"type": "tuic", "tag": "tuic", "server": "1.2.3.4", "server_port": 443},
+    {"type": "direct", "tag": "direct"},
+    {"type": "selector", "tag": "select", "outbounds": ["vless ws", "ss"]}
+  ],
+  "endpoints": [
+    {
+      "type": "wireguard", "tag": "wg",
+      "address": ["10.0.0.2/32"], "private_key": "c2VjcmV0",
+      "peers": [{"address": "1.2.3.4", "port": 51820, "public_key": "cHVibGlj"}]
+    }
+  ]
+}
+`
+
+func TestParse_Singbox(t *testing.T) {
+	expected := []string{"vless://", "ss://", "wireguard://"}
+	urls, e := Parse_Singbox([]byte(singbox_sample))
+	if nil != e {
+		t.Fatalf("Parse_Singbox failed: %v\n", e)
+	}
+	if len(urls) != len(expected) { // tuic is not supported
+		t.Fatalf("expected %d URLs, got %d: %v\n", len(expected), len(urls), urls)
+	}
+	for i := range urls {
+		if !strings.HasPrefix(urls[i], expected[i]) {
+			t.Fatalf("URL #%d: expected '%s...', got '%s'\n", i, expected[i], urls[i])
+		}
+	}
+	sub_assert(t, "sing-box", singbox_sample, urls)
+
+	// And back to sing-box
+	s := NewSingboxOutbounds()
+	for _, url := range urls {
+		if e = s.Add_URL(url); nil != e {
+			t.Fatalf("Add_URL failed: %v\n", e)
+		}
+	}
+	var out bytes.Buffer
+	if e = s.Out(&out); nil != e {
+		t.Fatalf("Out failed: %v\n", e)
+	}
+	res, e := Parse_Singbox(out.Bytes())
+	if nil != e {
+		t.Fatalf("Parse_Singbox failed: %v\n", e)
+	}
+	if len(res) != len(urls) {
+		t.Fatalf("unexpected round trip: %v\n", res)
+	}
+	for i := range urls {
+		if res[i] != urls[i] {
+			t.Fatalf("URL #%d: expected '%s', got '%s'\n", i, urls[i], res[i])
+		}
+	}
+}
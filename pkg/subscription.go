@@ -0,0 +1,360 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"io"
+	"fmt"
+	"time"
+	"bytes"
+	"bufio"
+	"errors"
+	"regexp"
+	"slices"
+	"context"
+	"strconv"
+	"strings"
+	"net/http"
+	"unicode/utf8"
+	"encoding/base64"
+
+	"github.com/siamak-amo/v2utils/internal"
+	log "github.com/siamak-amo/v2utils/log"
+)
+
+// Reads proxy URLs of subscriptions, line by line
+// Input can be plain links, base64 blobs of links (the whole
+// input or some lines), or a mixture of them.
+// Clash (Mihomo) yaml and sing-box json configs are also accepted,
+// see Parse_Clash and Parse_Singbox.
+// Empty lines and comments (#) are ignored, other non-link
+// lines are skipped with a warning.
+type SubscriptionReader struct {
+	scanner *bufio.Scanner
+	pending []string        // decoded links, to be returned first
+	lookahead string        // the line after a base64 blob
+	started bool            // the first line was read
+	Proxy_Links bool        // accept http(s) proxy URLs, see Is_Proxy_Link
+}
+
+// The first line of yaml configs, like `proxies:`
+var yaml_key_regex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*:(\s|$)`)
+// The top-level key of Clash configs
+var clash_proxies_regex = regexp.MustCompile(`(?m)^proxies:\s*$`)
+
+func new_scanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	// Subscription blobs are usually a single long line
+	scanner.Buffer(make([]byte, 64 * 1024), 16 * 1024 * 1024)
+	return scanner
+}
+
+func NewSubscriptionReader(r io.Reader) *SubscriptionReader {
+	return &SubscriptionReader{
+		scanner: new_scanner(r),
+	};
+}
+
+// Decodes the whole subscription @data
+func Decode_Subscription(data []byte) []string {
+	var res []string
+	sub := NewSubscriptionReader(bytes.NewReader(data))
+	for link, ok := sub.Next(); ok; link, ok = sub.Next() {
+		res = append(res, link)
+	}
+	return res
+}
+
+func is_base64_line(line string) bool {
+	for _, c := range line {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9':
+		case '+' == c, '/' == c, '-' == c, '_' == c, '=' == c:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Decodes standard and URL-safe base64, padded or not
+func decode_base64(blob string) ([]byte, bool) {
+	blob = strings.TrimRight(blob, "=")
+	for _, enc := range []*base64.Encoding{
+		base64.RawStdEncoding, base64.RawURLEncoding,
+	} {
+		if decoded, e := enc.DecodeString(blob); nil == e {
+			return decoded, utf8.Valid(decoded)
+		}
+	}
+	return nil, false
+}
+
+func skip_line(line, reason string) {
+	if len(line) > 64 {
+		line = line[:64] + "..."
+	}
+	log.Warnf("Subscription line '%s' was ignored - %s\n", line, reason);
+}
+
+// Why @line is not a link of @sub
+func (sub *SubscriptionReader) skip_reason(line string) string {
+	scheme, _, ok := strings.Cut(line, "://")
+	if !ok || !slices.Contains(internal.HTTP_Schemes, strings.ToLower(scheme)) {
+		return "not a link"
+	}
+	if sub.Proxy_Links {
+		return "http(s) proxies need credentials or a port"
+	}
+	return "http(s) URLs of subscriptions are not proxies"
+}
+
+// Returns the next link, false at the end of input
+func (sub *SubscriptionReader) Next() (string, bool) {
+	for {
+		if 0 != len(sub.pending) {
+			link := sub.pending[0]
+			sub.pending = sub.pending[1:]
+			return link, true
+		}
+		first := !sub.started
+		line, ok := sub.next_line()
+		if !ok {
+			return "", false
+		}
+		if sub.is_link(line) {
+			return line, true
+		}
+		if first && ('{' == line[0] || yaml_key_regex.MatchString(line)) {
+			sub.pending = sub.read_config(line)
+			continue;
+		}
+		if !is_base64_line(line) {
+			skip_line(line, sub.skip_reason(line));
+			continue;
+		}
+
+		// Wrapped blobs take more than one line
+		blob := []string{line}
+		for {
+			next, ok := sub.next_line()
+			if !ok {
+				break;
+			}
+			if sub.is_link(next) || !is_base64_line(next) {
+				sub.lookahead = next
+				break;
+			}
+			blob = append(blob, next)
+		}
+		sub.pending = expand_blob(blob)
+	}
+}
+
+func (sub *SubscriptionReader) is_link(line string) bool {
+	if sub.Proxy_Links {
+		return internal.Is_Proxy_Link(line)
+	}
+	return internal.Is_Link(line)
+}
+
+// Decodes lines of a base64 blob, as a whole (wrapped blob)
+// or line by line (independent blobs)
+func expand_blob(blob []string) []string {
+	decode := func(b string) []string {
+		if decoded, ok := decode_base64(b); ok {
+			return Decode_Subscription(decoded)
+		}
+		return nil
+	}
+	if links := decode(strings.Join(blob, "")); 0 != len(links) {
+		return links
+	}
+	var res []string
+	for _, line := range blob {
+		links := decode(line)
+		if 1 == len(blob) || 0 == len(links) {
+			skip_line(line, "not a link");
+			continue;
+		}
+		res = append(res, links...)
+	}
+	return res
+}
+
+// Reads the rest of input as sing-box json or Clash yaml config
+// @first:  the already read first line
+func (sub *SubscriptionReader) read_config(first string) []string {
+	data := []byte(first + "\n")
+	for sub.scanner.Scan() {
+		data = append(data, sub.scanner.Bytes()...)
+		data = append(data, '\n')
+	}
+	if '{' == first[0] {
+		links, e := Parse_Singbox(data)
+		if nil != e {
+			log.Errorf("Reading sing-box config failed - %v\n", e);
+		}
+		return links
+	}
+	// Not Clash (e.g. a `Note: ...` line before links), read line by line
+	if !clash_proxies_regex.Match(data) {
+		sub.scanner = new_scanner(bytes.NewReader(data))
+		return nil
+	}
+	links, e := Parse_Clash(data)
+	if nil != e {
+		log.Errorf("Reading Clash config failed - %v\n", e);
+	}
+	return links
+}
+
+// Returns the next non-empty, non-comment line
+func (sub *SubscriptionReader) next_line() (string, bool) {
+	if "" != sub.lookahead {
+		line := sub.lookahead
+		sub.lookahead = ""
+		return line, true
+	}
+	for sub.scanner.Scan() {
+		line := strings.TrimSpace(sub.scanner.Text())
+		if 0 == len(line) || '#' == line[0] {
+			continue;
+		}
+		sub.started = true
+		return line, true
+	}
+	if e := sub.scanner.Err(); nil != e {
+		log.Errorf("Reading subscription failed - %v\n", e);
+	}
+	return "", false
+}
+
+
+var (
+	// Timeout of downloading subscriptions
+	SubscriptionTimeout time.Duration = 30 * time.Second
+	// Some providers choose the format by User-Agent
+	SubscriptionUserAgent = "v2utils"
+	// Maximum size of subscription body
+	SubscriptionMaxSize int64 = 16 * 1024 * 1024
+)
+
+// The `subscription-userinfo` header
+// Traffics are in bytes, Expire is unix time, zero means unknown
+type SubscriptionInfo struct {
+	Upload   int64
+	Download int64
+	Total    int64
+	Expire   int64
+}
+
+// @header:  "upload=1024; download=2048; total=10240; expire=1700000000"
+// Returns nil if @header has none of the keys
+func Parse_SubscriptionInfo(header string) *SubscriptionInfo {
+	info := &SubscriptionInfo{}
+	found := false
+	for _, kv := range strings.Split(header, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok {
+			continue;
+		}
+		// Some providers send floats
+		f, e := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if nil != e {
+			continue;
+		}
+		switch (strings.ToLower(strings.TrimSpace(k))) {
+		case "upload":
+			info.Upload = int64(f)
+		case "download":
+			info.Download = int64(f)
+		case "total":
+			info.Total = int64(f)
+		case "expire":
+			info.Expire = int64(f)
+		default:
+			continue;
+		}
+		found = true
+	}
+	if !found {
+		return nil
+	}
+	return info
+}
+
+func human_size(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	f := float64(n)
+	i := 0
+	for ; f >= 1024 && i < len(units)-1; i += 1 {
+		f /= 1024
+	}
+	if 0 == i {
+		return fmt.Sprintf("%d%s", n, units[0])
+	}
+	return fmt.Sprintf("%.2f%s", f, units[i])
+}
+
+func (info SubscriptionInfo) String() string {
+	res := fmt.Sprintf("upload: %s, download: %s",
+		human_size(info.Upload), human_size(info.Download))
+	if 0 != info.Total {
+		res += fmt.Sprintf(", total: %s, remaining: %s", human_size(info.Total),
+			human_size(info.Total - info.Upload - info.Download))
+	}
+	if 0 != info.Expire {
+		res += ", expire: " + time.Unix(info.Expire, 0).Format(time.DateOnly)
+	}
+	return res
+}
+
+// Runs xray-core by proxy URL or config file @proxy,
+// to download subscriptions through it (see Fetch_Subscription)
+func (v2 *V2utils) Run_Bootstrap(proxy string) error {
+	if internal.Is_Proxy_Link(proxy) {
+		v2.Apply_template_bystr(DEF_Test_Template);
+		if e := v2.Init_Outbound_byURL(proxy); nil != e {
+			return e
+		}
+	} else {
+		if e := v2.Load_CFG(proxy); nil != e {
+			return e
+		}
+		v2.prepare_test_cfg()
+	}
+	return v2.Run_Xray();
+}
+
+// Downloads subscription @url
+// It goes through @v2.Xray_instance if it's running (see Run_Bootstrap)
+func (v2 V2utils) Fetch_Subscription(url string) ([]byte, *SubscriptionInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), SubscriptionTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if nil != err {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", SubscriptionUserAgent)
+
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if nil != v2.Xray_instance {
+		transport = &http.Transport{DialContext: v2.CustomDial}
+	}
+	client := http.Client{Transport: transport}
+	resp, err := client.Do(req)
+	if nil != err {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if http.StatusOK != resp.StatusCode {
+		return nil, nil, errors.New("HTTP status " + resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, SubscriptionMaxSize))
+	if nil != err {
+		return nil, nil, err
+	}
+	return body, Parse_SubscriptionInfo(resp.Header.Get("subscription-userinfo")), nil
+}
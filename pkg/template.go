@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"os"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"encoding/json"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// Tag of the placeholder outbound of templates
+// It's replaced by the generated outbound(s), and the other
+// outbounds of the template (e.g. direct, block) are kept
+// Without placeholder, the generated outbounds are added before
+// the others, to be the default route
+var PlaceholderTag = "proxy"
+
+// Template variables: ${NAME} and ${NAME:-default}
+var template_var_regex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// Substitutes variables of @template, by @v2.Vars, then environment
+// variables, and then their default value, if they are empty
+// Returns error listing the unresolved variables
+func (v2 V2utils) Expand_template(template []byte) ([]byte, error) {
+	var unresolved []string
+	res := template_var_regex.ReplaceAllFunc(template, func(match []byte) []byte {
+		sub := template_var_regex.FindSubmatch(match)
+		name := string(sub[1])
+		value, ok := v2.Vars[name]
+		if !ok {
+			value, ok = os.LookupEnv(name)
+		}
+		if "" == value && 0 != len(sub[2]) {
+			value = string(sub[3])
+			ok = true
+		}
+		if !ok {
+			if !slices.Contains(unresolved, name) {
+				unresolved = append(unresolved, name)
+			}
+			return match
+		}
+		return []byte(value)
+	})
+	if 0 != len(unresolved) {
+		return nil, fmt.Errorf("unresolved template variables: %s", strings.Join(unresolved, ", "))
+	}
+	return res, nil
+}
+
+// Keeps outbounds and inbounds of the applied template, as they are
+// replaced by each call of Init_Outbound_xxx and Init_Inbound_xxx
+func (v2 *V2utils) template_loaded() {
+	v2.set_template = true
+	v2.template_outbounds, v2.template_inbounds = nil, nil
+	if nil != v2.CFG {
+		v2.template_outbounds = append(v2.template_outbounds, v2.CFG.OutboundConfigs...)
+		v2.template_inbounds = append(v2.template_inbounds, v2.CFG.InboundConfigs...)
+	}
+}
+
+// Puts @outbounds in place of the placeholder outbound of the template
+func (v2 *V2utils) set_outbounds(outbounds []conf.OutboundDetourConfig) {
+	var res []conf.OutboundDetourConfig
+	replaced := false
+	for _, ob := range v2.template_outbounds {
+		if !replaced && PlaceholderTag == ob.Tag {
+			res = append(res, outbounds...)
+			replaced = true
+			continue;
+		}
+		res = append(res, ob)
+	}
+	if !replaced {
+		res = append(append([]conf.OutboundDetourConfig{}, outbounds...), res...)
+	}
+	v2.CFG.OutboundConfigs = res
+}
+
+// Tags which a routing rule refers to
+type rule_tags struct {
+	OutboundTag string `json:"outboundTag"`
+	BalancerTag string `json:"balancerTag"`
+}
+
+// Checks all the outbound and balancer tags, which are referred by
+// routing rules, balancers and dialer proxies, exist in @v2.CFG
+func (v2 V2utils) Check_Routing() error {
+	outbounds := make(map[string]bool)
+	for _, ob := range v2.CFG.OutboundConfigs {
+		outbounds[ob.Tag] = true
+	}
+	for _, ob := range v2.CFG.OutboundConfigs {
+		if tag := dialer_proxy_of(&ob); "" != tag && !outbounds[tag] {
+			return fmt.Errorf("outbound '%s' dials through missing outbound '%s'", ob.Tag, tag)
+		}
+	}
+	router := v2.CFG.RouterConfig
+	if nil == router {
+		return nil
+	}
+
+	balancers := make(map[string]bool)
+	for _, b := range router.Balancers {
+		balancers[b.Tag] = true
+		if "" != b.FallbackTag && !outbounds[b.FallbackTag] {
+			return fmt.Errorf("balancer '%s' has missing fallback outbound '%s'", b.Tag, b.FallbackTag)
+		}
+		if !selects_any(b.Selectors, outbounds) {
+			return fmt.Errorf("balancer '%s' selects no outbound, selector: %v", b.Tag, []string(b.Selectors))
+		}
+	}
+	for i, raw := range router.RuleList {
+		var rule rule_tags
+		if e := json.Unmarshal(raw, &rule); nil != e {
+			return fmt.Errorf("routing rule #%d - %w", i + 1, e)
+		}
+		if "" != rule.OutboundTag && !outbounds[rule.OutboundTag] {
+			return fmt.Errorf("routing rule #%d refers to missing outbound '%s'", i + 1, rule.OutboundTag)
+		}
+		if "" != rule.BalancerTag && !balancers[rule.BalancerTag] {
+			return fmt.Errorf("routing rule #%d refers to missing balancer '%s'", i + 1, rule.BalancerTag)
+		}
+	}
+	return nil
+}
+
+func dialer_proxy_of(ob *conf.OutboundDetourConfig) string {
+	if nil != ob.ProxySettings && "" != ob.ProxySettings.Tag {
+		return ob.ProxySettings.Tag
+	}
+	if nil != ob.StreamSetting && nil != ob.StreamSetting.SocketSettings {
+		return ob.StreamSetting.SocketSettings.DialerProxy
+	}
+	return ""
+}
+
+// Balancer selectors are tag prefixes
+func selects_any(selectors conf.StringList, tags map[string]bool) bool {
+	for _, prefix := range selectors {
+		for tag := range tags {
+			if strings.HasPrefix(tag, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Routes rules of the placeholder outbound to balancer @tag
+func (v2 *V2utils) route_placeholder(tag string) error {
+	for i, raw := range v2.CFG.RouterConfig.RuleList {
+		var rule map[string]any
+		if e := json.Unmarshal(raw, &rule); nil != e {
+			return fmt.Errorf("routing rule #%d - %w", i + 1, e)
+		}
+		if PlaceholderTag != rule["outboundTag"] {
+			continue;
+		}
+		delete(rule, "outboundTag")
+		rule["balancerTag"] = tag
+		res, e := json.Marshal(rule)
+		if nil != e {
+			return e
+		}
+		v2.CFG.RouterConfig.RuleList[i] = res
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"path/filepath"
+)
+
+const test_template = `{
+    "log": {"loglevel": "none"},
+    "dns": {"servers": ["1.1.1.1"]},
+    "outbounds": [
+        {"tag": "direct", "protocol": "freedom"},
+        {"tag": "proxy", "protocol": "freedom"},
+        {"tag": "block", "protocol": "blackhole"}
+    ],
+    "routing": {
+        "rules": [
+            {"type": "field", "domain": ["domain:ir"], "outboundTag": "direct"},
+            {"type": "field", "domain": ["domain:ads.com"], "outboundTag": "block"},
+            {"type": "field", "network": "tcp,udp", "outboundTag": "proxy"}
+        ]
+    }
+}`
+
+func template_tags(v2 *V2utils) string {
+	var tags []string
+	for _, ob := range v2.CFG.OutboundConfigs {
+		tags = append(tags, ob.Tag)
+	}
+	return strings.Join(tags, ",")
+}
+
+func TestTemplate_Placeholder(t *testing.T) {
+	v2 := V2utils{}
+	if e := v2.Apply_template_bystr(test_template); nil != e {
+		t.Fatalf("Apply_template failed: %v\n", e)
+	}
+	// Applying URLs twice must not accumulate outbounds
+	for _, url := range sub_links[:2] {
+		if e := v2.Init_Outbound_byURL(url); nil != e {
+			t.Fatalf("Init_Outbound_byURL failed: %v\n", e)
+		}
+		if tags := template_tags(&v2); "direct,proxy,block" != tags {
+			t.Fatalf("expected outbounds direct,proxy,block, got %s\n", tags)
+		}
+		if "freedom" == v2.CFG.OutboundConfigs[1].Protocol {
+			t.Fatalf("placeholder was not replaced\n")
+		}
+		if nil == v2.CFG.DNSConfig || 3 != len(v2.CFG.RouterConfig.RuleList) {
+			t.Fatalf("dns or routing of the template was lost\n")
+		}
+		if _, e := v2.CFG.Build(); nil != e {
+			t.Fatalf("Build failed: %v\n", e)
+		}
+	}
+
+	// Chain replaces the placeholder with all of its hops
+	if e := v2.Init_Outbound_byChain(sub_links[:2]); nil != e {
+		t.Fatalf("Init_Outbound_byChain failed: %v\n", e)
+	}
+	if tags := template_tags(&v2); "direct,proxy,chain-1,block" != tags {
+		t.Fatalf("chain: unexpected outbounds %s\n", tags)
+	}
+
+	// Balancer takes over rules of the placeholder
+	if e := v2.Init_Outbounds_byURLs(sub_links, "random"); nil != e {
+		t.Fatalf("Init_Outbounds_byURLs failed: %v\n", e)
+	}
+	if tags := template_tags(&v2); "direct,v2utils-balancer-1,v2utils-balancer-2,v2utils-balancer-3,block" != tags {
+		t.Fatalf("balancer: unexpected outbounds %s\n", tags)
+	}
+	if !strings.Contains(string(v2.CFG.RouterConfig.RuleList[2]), `"balancerTag":"balancer"`) {
+		t.Fatalf("rule of the placeholder was not routed to the balancer\n")
+	}
+	if _, e := v2.CFG.Build(); nil != e {
+		t.Fatalf("balancer: Build failed: %v\n", e)
+	}
+}
+
+func TestTemplate_NoPlaceholder(t *testing.T) {
+	v2 := V2utils{}
+	tmpl := strings.Replace(test_template, `"tag": "proxy"`, `"tag": "other"`, 1)
+	tmpl = strings.Replace(tmpl, `"outboundTag": "proxy"`, `"outboundTag": "other"`, 1)
+	if e := v2.Apply_template_bystr(tmpl); nil != e {
+		t.Fatalf("Apply_template failed: %v\n", e)
+	}
+	if e := v2.Init_Outbound_byURL(sub_links[0]); nil != e {
+		t.Fatalf("Init_Outbound_byURL failed: %v\n", e)
+	}
+	if tags := template_tags(&v2); "proxy,direct,other,block" != tags {
+		t.Fatalf("expected the proxy as the first outbound, got %s\n", tags)
+	}
+}
+
+func TestCheck_Routing(t *testing.T) {
+	v2 := V2utils{}
+	tmpl := strings.Replace(test_template, `"outboundTag": "block"`, `"outboundTag": "reject"`, 1)
+	if e := v2.Apply_template_bystr(tmpl); nil != e {
+		t.Fatalf("Apply_template failed: %v\n", e)
+	}
+	e := v2.Init_Outbound_byURL(sub_links[0])
+	if nil == e {
+		t.Fatalf("missing outbound was not detected\n")
+	}
+	if !strings.Contains(e.Error(), "rule #2") || !strings.Contains(e.Error(), "'reject'") {
+		t.Fatalf("unclear error: %v\n", e)
+	}
+
+	tmpl = strings.Replace(test_template, `"outboundTag": "block"`, `"balancerTag": "lb"`, 1)
+	v2.Apply_template_bystr(tmpl)
+	if e := v2.Init_Outbound_byURL(sub_links[0]); nil == e || !strings.Contains(e.Error(), "'lb'") {
+		t.Fatalf("missing balancer was not detected: %v\n", e)
+	}
+}
+
+func TestExpand_template(t *testing.T) {
+	t.Setenv("V2U_TEST_LEVEL", "error")
+	t.Setenv("V2U_TEST_EMPTY", "")
+	v2 := V2utils{Vars: map[string]string{"PORT": "2080"}}
+	tmpl := `{"log":{"loglevel":"${V2U_TEST_LEVEL}"}, "inbounds":[{"port":${PORT:-1080},` +
+		`"listen":"${LISTEN:-127.0.0.1}","protocol":"socks${V2U_TEST_EMPTY}"}]}`
+	res, e := v2.Expand_template([]byte(tmpl))
+	if nil != e {
+		t.Fatalf("Expand_template failed: %v\n", e)
+	}
+	expected := `{"log":{"loglevel":"error"}, "inbounds":[{"port":2080,` +
+		`"listen":"127.0.0.1","protocol":"socks"}]}`
+	if expected != string(res) {
+		t.Fatalf("expected %s, got %s\n", expected, res)
+	}
+	if e := v2.Apply_template_bystr(tmpl); nil != e {
+		t.Fatalf("Apply_template_bystr failed: %v\n", e)
+	}
+	if 2080 != v2.CFG.InboundConfigs[0].PortList.Range[0].From {
+		t.Fatalf("port variable was not applied\n")
+	}
+
+	_, e = v2.Expand_template([]byte(`${V2U_X} ${V2U_Y} ${V2U_X} ${V2U_Z:-z}`))
+	if nil == e || "unresolved template variables: V2U_X, V2U_Y" != e.Error() {
+		t.Fatalf("unexpected error: %v\n", e)
+	}
+	if e := v2.Apply_template_byio(strings.NewReader(`{"dns":{"servers":["${V2U_X}"]}}`)); nil == e {
+		t.Fatalf("unresolved variable was accepted\n")
+	}
+}
+
+// Configs are not templates, their ${...} are kept as is
+func TestLoad_CFG(t *testing.T) {
+	cfg := `{"outbounds":[{"tag":"${V2U_X}","protocol":"freedom"}]}`
+	path := filepath.Join(t.TempDir(), "config.json")
+	if e := os.WriteFile(path, []byte(cfg), 0644); nil != e {
+		t.Fatalf("WriteFile failed: %v\n", e)
+	}
+	v2 := V2utils{}
+	if e := v2.Load_CFG(path); nil != e {
+		t.Fatalf("Load_CFG failed: %v\n", e)
+	}
+	if tag := v2.CFG.OutboundConfigs[0].Tag; "${V2U_X}" != tag {
+		t.Fatalf("config was modified, tag '%s'\n", tag)
+	}
+	if e := v2.Load_CFG_byio(strings.NewReader(cfg)); nil != e {
+		t.Fatalf("Load_CFG_byio failed: %v\n", e)
+	}
+	if tag := v2.CFG.OutboundConfigs[0].Tag; "${V2U_X}" != tag {
+		t.Fatalf("config was modified, tag '%s'\n", tag)
+	}
+	if e := v2.Apply_template(path); nil == e {
+		t.Fatalf("unresolved variable of template was accepted\n")
+	}
+}
+
+func TestApply_templates(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"01_inbounds.json": `{"inbounds":[{"tag":"socks","port":${PORT:-1080},"protocol":"socks"}]}`,
+		"02_routing.json": `{"outbounds":[{"tag":"proxy","protocol":"freedom"}],` +
+			`"routing":{"rules":[{"type":"field","domain":["domain:ir"],"outboundTag":"direct"}]}}`,
+		"03_tail.json": `{"outbounds":[{"tag":"direct","protocol":"freedom"}]}`,
+		"notes.txt": `not a config`,
+	}
+	for name, content := range files {
+		if e := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); nil != e {
+			t.Fatalf("WriteFile failed: %v\n", e)
+		}
+	}
+	override := filepath.Join(t.TempDir(), "override.yaml")
+	os.WriteFile(override, []byte("inbounds:\n- {tag: socks, port: 2080, protocol: socks}\n" +
+		"- {tag: http, port: 8080, protocol: http}\n"), 0644)
+
+	v2 := V2utils{}
+	if e := v2.Apply_templates([]string{dir, override}); nil != e {
+		t.Fatalf("Apply_templates failed: %v\n", e)
+	}
+	if 2 != len(v2.CFG.InboundConfigs) ||
+		2080 != v2.CFG.InboundConfigs[0].PortList.Range[0].From {
+		t.Fatalf("inbounds were not merged by tag\n")
+	}
+	if e := v2.Init_Outbound_byURL(sub_links[0]); nil != e {
+		t.Fatalf("Init_Outbound_byURL failed: %v\n", e)
+	}
+	if tags := template_tags(&v2); "proxy,direct" != tags {
+		t.Fatalf("expected outbounds proxy,direct, got %s\n", tags)
+	}
+	if _, e := v2.CFG.Build(); nil != e {
+		t.Fatalf("Build failed: %v\n", e)
+	}
+
+	if e := v2.Apply_templates([]string{filepath.Join(dir, "notes.txt")}); nil == e {
+		t.Fatalf("invalid template was accepted\n")
+	}
+	if e := v2.Apply_templates([]string{t.TempDir()}); nil == e {
+		t.Fatalf("empty directory was accepted\n")
+	}
+}
+
+func TestRemark(t *testing.T) {
+	// A proxy outbound before the placeholder
+	tmpl := strings.Replace(test_template,
+		`{"tag": "direct", "protocol": "freedom"},`,
+		`{"tag": "direct", "protocol": "freedom"},
+        {"tag": "backup", "protocol": "socks", "settings": {"servers": [{"address": "9.9.9.9", "port": 1080}]}},`, 1)
+	v2 := V2utils{}
+	if e := v2.Apply_template_bystr(tmpl); nil != e {
+		t.Fatalf("Apply_template failed: %v\n", e)
+	}
+	if e := v2.Init_Outbound_byURL("trojan://p@1.2.3.4:443#my-proxy"); nil != e {
+		t.Fatalf("Init_Outbound_byURL failed: %v\n", e)
+	}
+	if r := v2.Remark(); "my-proxy" != r {
+		t.Fatalf("expected remark of the placeholder, got '%s'\n", r)
+	}
+
+	// No placeholder, first proxy outbound after freedom
+	no_placeholder := `{"outbounds": [
+        {"tag": "direct", "protocol": "freedom"},
+        {"tag": "a", "protocol": "socks", "settings": {"remark": "first proxy",
+            "servers": [{"address": "9.9.9.9", "port": 1080}]}}
+    ]}`
+	v2 = V2utils{}
+	if e := v2.Apply_template_bystr(no_placeholder); nil != e {
+		t.Fatalf("Apply_template failed: %v\n", e)
+	}
+	if r := v2.Remark(); "first proxy" != r {
+		t.Fatalf("expected remark of the first proxy, got '%s'\n", r)
+	}
+	// The URL is added before them, as the default route
+	if e := v2.Init_Outbound_byURL("trojan://p@1.2.3.4:443#my-proxy"); nil != e {
+		t.Fatalf("Init_Outbound_byURL failed: %v\n", e)
+	}
+	if r := v2.Remark(); "my-proxy" != r {
+		t.Fatalf("expected remark of the URL, got '%s'\n", r)
+	}
+}
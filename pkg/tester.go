@@ -4,17 +4,23 @@ package pkg
 import (
 	"io"
 	"time"
+	"slices"
 	"errors"
 	"context"
+	"sync"
 
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"crypto/tls"
 
 	log "github.com/siamak-amo/v2utils/log"
 
 	core "github.com/xtls/xray-core/core"
+	session "github.com/xtls/xray-core/common/session"
 	xnet "github.com/xtls/xray-core/common/net"
 	conf "github.com/xtls/xray-core/infra/conf"
+	internet "github.com/xtls/xray-core/transport/internet"
 )
 
 const (
@@ -26,6 +32,14 @@ const (
     Test_Endpoint_ip2 = "http://v4.api.ipinfo.io/ip"
     Test_Endpoint_ip3 = "http://check-host.net/ip"
     Test_Endpoint_ip4 = "http://ipv4.icanhazip.com"
+
+	// IPv6 only APIs to report IP address
+	Test_Endpoint_ip6_1 = "http://api6.ipify.org"
+	Test_Endpoint_ip6_2 = "http://v6.ipinfo.io/ip"
+	Test_Endpoint_ip6_3 = "http://ipv6.icanhazip.com"
+
+	// https endpoint to time the phases (see TestPhases)
+	Test_Endpoint_phases = "https://www.google.com/generate_204"
 )
 
 var (
@@ -33,14 +47,49 @@ var (
 	TestTimeout time.Duration = 10 * time.Second
 	// Maximum number of endpoints to test
 	TestCount int = 3
+	// Number of probes of the working endpoint, for latency statistics
+	// They are after the first (cold) probe, the test itself, see test_rounds
+	TestRounds int = 0
 
 	// Returned when max allowed tests failed
 	Not_Responding_Error = errors.New("Not responding")
+	// Returned by BatchTester on non-URL jobs
+	Not_Supported_Error = errors.New("Not supported in batch mode")
+
+	// tls config of test requests, nil means the system roots
+	test_tls_config *tls.Config
+	// Clock of the phases of test requests
+	phase_now = time.Now
+	// System dialer of xray-core, see use_phase_dialer
+	phase_system_dialer = &phase_dialer{SystemDialer: &internet.DefaultSystemDialer{}}
 )
 
 type TestResult struct {
 	IP string
-	Duration int64
+	IPv6 string     // by IPv6Tester, empty when egress has no IPv6
+	Duration int64      // of the first (cold) probe
+	Stats *LatencyStats // of the TestRounds probes, only when TestRounds > 0
+	Phases *TestPhases  // of the https probe (verbose testers)
+}
+
+// Timing (ms) of phases of a test request, one after another
+// Xray dials lazily, by the first write through the connection,
+// and sends the proxy handshake along with it, so the handshake
+// is in TLS (or FirstByte of http endpoints)
+type TestPhases struct {
+	Dial int64      // connecting to the proxy server (new connections only)
+	TLS int64       // the TLS handshake with the endpoint (https only)
+	FirstByte int64 // the rest of the way to the first byte of response
+}
+
+// Latency statistics (ms) of repeated probes, see TestRounds
+type LatencyStats struct {
+	Rounds int      // number of probes, excluding the first (cold) one
+	Min int64
+	Median int64
+	P95 int64
+	Jitter int64    // mean difference of consecutive probes
+	Loss float64    // percentage of failed probes
 }
 
 type ConnectivityTester_I interface {
@@ -54,6 +103,13 @@ type Simple_Contester struct {
 // Connectivity tester with IP report
 type IP_Contester struct {
 	endpoints []string
+	no_rounds bool  // ignore TestRounds (IPv6 of IPv6Tester)
+	phases string   // https endpoint of TestPhases, see test_phases
+}
+// Connectivity tester with IPv4 and IPv6 report
+type IPv6_Contester struct {
+	v4 ConnectivityTester_I
+	v6 ConnectivityTester_I  // its IP is reported as IPv6
 }
 
 
@@ -74,17 +130,33 @@ var (
 			Test_Endpoint_ip1, Test_Endpoint_ip2,
 			Test_Endpoint_ip3, Test_Endpoint_ip4,
 		},
+		phases: Test_Endpoint_phases,
+	};
+
+	// Also checks whether the egress has IPv6
+	IPv6Tester = &IPv6_Contester{
+		v4: AdvancedTester,
+		v6: &IP_Contester{
+			endpoints: []string{
+				Test_Endpoint_ip6_1, Test_Endpoint_ip6_2, Test_Endpoint_ip6_3,
+			},
+			no_rounds: true,
+		},
 	};
 )
 
 func (tester *Simple_Contester) Test(v2 *V2utils) (error, *TestResult) {
+	client := v2.test_client()
+	defer client.CloseIdleConnections()
 	for n := 0;; {
 		for _, endpoint := range tester.endpoints {
 			if n += 1; n > TestCount {
 				return Not_Responding_Error, nil;
 			}
-			if err, dur, _ := v2.test_http(endpoint, false); nil == err {
-				return nil, &TestResult{ Duration: dur };
+			if err, dur, _, phases := v2.test_http(client, endpoint, false); nil == err {
+				res := &TestResult{ Duration: dur, Phases: phases }
+				v2.test_rounds(client, endpoint, res);
+				return nil, res;
 			} else {
 				log.Debugf("Test failed - %s\n", err);
 			}
@@ -94,13 +166,15 @@ func (tester *Simple_Contester) Test(v2 *V2utils) (error, *TestResult) {
 }
 
 func (tester *IP_Contester) Test(v2 *V2utils) (error, *TestResult) {
+	client := v2.test_client()
+	defer client.CloseIdleConnections()
 	for n := 0;; {
 		for _, endpoint := range tester.endpoints {
 			if n += 1; n > TestCount {
 				return Not_Responding_Error, nil;
 			}
-			if err, dur, body := v2.test_http(endpoint, true); nil == err {
-				res := &TestResult{ Duration: dur }
+			if err, dur, body, phases := v2.test_http(client, endpoint, true); nil == err {
+				res := &TestResult{ Duration: dur, Phases: phases }
 				if ip := net.ParseIP(string(body)); nil != ip {
 					res.IP = ip.String()
 				} else {
@@ -111,6 +185,12 @@ func (tester *IP_Contester) Test(v2 *V2utils) (error, *TestResult) {
 					log.Debugf("Expected IP in the response of %s, but got: %s\n",
 						endpoint, string(body));
 				}
+				if !tester.no_rounds {
+					v2.test_rounds(client, endpoint, res);
+				}
+				if "" != tester.phases {
+					res.Phases = v2.test_phases(client, tester.phases)
+				}
 				return nil, res;
 			} else {
 				log.Debugf("Test failed - %s\n", err);
@@ -120,6 +200,91 @@ func (tester *IP_Contester) Test(v2 *V2utils) (error, *TestResult) {
 	return Not_Responding_Error, nil;
 }
 
+// Egress without IPv4 (IPv6 only) is not considered broken,
+// but one without any verified address is
+func (tester *IPv6_Contester) Test(v2 *V2utils) (error, *TestResult) {
+	err, res := tester.v4.Test(v2);
+	err6, res6 := tester.v6.Test(v2);
+	if nil != err6 {
+		log.Debugf("IPv6 test failed - %s\n", err6);
+		return err, res;
+	}
+	if ip := net.ParseIP(res6.IP); nil == ip || nil != ip.To4() {
+		res6.IP = "" // Not an IPv6 address
+	}
+	if nil != err {
+		if "" == res6.IP {
+			return err, nil;
+		}
+		res = &TestResult{ Duration: res6.Duration, Phases: res6.Phases }
+	}
+	res.IPv6 = res6.IP
+	return nil, res;
+}
+
+// Probes https @endpoint for TestPhases, as http ones have no TLS
+// Returns nil if it fails, it does not fail the test
+func (v2 V2utils) test_phases(client *http.Client, endpoint string) *TestPhases {
+	err, _, _, phases := v2.test_http(client, endpoint, false)
+	if nil != err {
+		log.Debugf("Phases probe failed - %s\n", err);
+		return nil
+	}
+	return phases
+}
+
+// Probes @endpoint TestRounds times, after the first successful
+// probe of @res.Duration, and sets latency statistics of @res
+// The first probe pays for the proxy handshake, so it's not a sample,
+// and the extra probes reuse its connection by @client (keep-alive)
+func (v2 V2utils) test_rounds(client *http.Client, endpoint string, res *TestResult) {
+	if TestRounds <= 0 {
+		return
+	}
+	var samples []int64
+	for i := 0; i < TestRounds; i++ {
+		if err, dur, _, _ := v2.test_http(client, endpoint, false); nil == err {
+			samples = append(samples, dur)
+		} else {
+			log.Debugf("Probe #%d failed - %s\n", i + 1, err);
+		}
+	}
+	res.Stats = Latency_Stats(samples, TestRounds)
+}
+
+// Calculates latency statistics of successful probes @samples (ms),
+// out of @rounds probes
+func Latency_Stats(samples []int64, rounds int) *LatencyStats {
+	res := &LatencyStats{Rounds: rounds}
+	if rounds > 0 {
+		res.Loss = float64(rounds - len(samples)) * 100 / float64(rounds)
+	}
+	if 0 == len(samples) {
+		return res
+	}
+	var diff int64
+	for i := 1; i < len(samples); i++ {
+		if d := samples[i] - samples[i-1]; d < 0 {
+			diff -= d
+		} else {
+			diff += d
+		}
+	}
+	if len(samples) > 1 {
+		res.Jitter = diff / int64(len(samples) - 1)
+	}
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+	res.Min = sorted[0]
+	res.Median = sorted[len(sorted) / 2]
+	if 0 == len(sorted) % 2 {
+		res.Median = (sorted[len(sorted)/2 - 1] + sorted[len(sorted)/2]) / 2
+	}
+	// Nearest-rank percentile
+	res.P95 = sorted[(len(sorted) * 95 + 99) / 100 - 1]
+	return res
+}
+
 func (v2 *V2utils) doTest(tester ConnectivityTester_I) (err error, res *TestResult) {
 	if e := v2.Run_Xray(); nil != e {
 		return e, nil;
@@ -130,16 +295,120 @@ func (v2 *V2utils) doTest(tester ConnectivityTester_I) (err error, res *TestResu
 }
 
 // This will utilize the xray-core Dial function (DialContext compatible)
+// If @v2.outbound_tag is set, the routing is bypassed and the
+// connection goes through that outbound (see BatchTester)
+// Dials of instances run by Run_Xray end by Kill_Xray
 func (v2 V2utils) CustomDial(ctx context.Context, network, addr string) (xnet.Conn, error) {
 	dst, e := xnet.ParseDestination(network + ":" + addr);
 	if nil != e {
 		return nil, e
 	}
-	return core.Dial(ctx, v2.Xray_instance, dst);
+	if "" != v2.outbound_tag {
+		ctx = session.SetForcedOutboundTagToContext(ctx, v2.outbound_tag);
+	}
+	if nil == v2.dials {
+		return core.Dial(ctx, v2.Xray_instance, dst);
+	}
+	return v2.dials.dial(ctx, v2.Xray_instance, dst);
+}
+
+// Returns http client of test requests, through @v2.Xray_instance
+// and the current outbound tag of @v2
+// Its connections are kept alive, so the caller closes them
+func (v2 V2utils) test_client() *http.Client {
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return v2.CustomDial(ctx, network, addr)
+	}
+	return &http.Client{Transport: &http.Transport{
+		DialContext: dial,
+		TLSClientConfig: test_tls_config,
+	}}
+}
+
+// Records TestPhases of a request, by httptrace and phase_dialer
+// Callbacks may run after the request is timed out, so
+// they are locked, and the result is made by get
+type phase_timer struct {
+	sync.Mutex
+	reused bool
+	get_conn, connected, tls_start, tls_done, wrote, first_byte time.Time
+}
+
+// Context key of the phase_timer of a request, for phase_dialer
+type phase_timer_key struct{}
+
+// Ends the Dial phase of test requests, when the proxy server
+// (the first hop of chains) is connected
+// The context of dials through xray-core reaches the system dialer
+type phase_dialer struct {
+	internet.SystemDialer
+}
+
+func (d *phase_dialer) Dial(ctx context.Context, src xnet.Address, dst xnet.Destination, sockopt *internet.SocketConfig) (net.Conn, error) {
+	conn, e := d.SystemDialer.Dial(ctx, src, dst, sockopt)
+	if timer, ok := ctx.Value(phase_timer_key{}).(*phase_timer); ok && nil == e {
+		timer.Lock(); defer timer.Unlock()
+		if timer.connected.IsZero() {
+			timer.connected = phase_now()
+		}
+	}
+	return conn, e
+}
+
+var phase_dialer_once sync.Once
+
+// Makes phase_system_dialer the system dialer of xray-core
+// It's global, so before the first instance (see Run_Xray)
+func use_phase_dialer() {
+	phase_dialer_once.Do(func() {
+		internet.UseAlternativeSystemDialer(phase_system_dialer)
+	})
+}
+
+func (p *phase_timer) trace() *httptrace.ClientTrace {
+	set := func(t *time.Time) {
+		p.Lock(); defer p.Unlock()
+		*t = phase_now()
+	}
+	return &httptrace.ClientTrace{
+		GetConn: func(string) { set(&p.get_conn) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			p.Lock(); defer p.Unlock()
+			p.reused = info.Reused
+		},
+		TLSHandshakeStart: func() { set(&p.tls_start) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) { set(&p.tls_done) },
+		WroteRequest: func(httptrace.WroteRequestInfo) { set(&p.wrote) },
+		GotFirstResponseByte: func() { set(&p.first_byte) },
+	}
+}
+
+// Each phase starts where the previous one ends
+func (p *phase_timer) get() *TestPhases {
+	p.Lock(); defer p.Unlock()
+	since := func(end, start time.Time) int64 {
+		if start.Before(p.connected) {
+			start = p.connected
+		}
+		return end.Sub(start).Milliseconds()
+	}
+	res := &TestPhases{}
+	if !p.reused && !p.connected.IsZero() {
+		res.Dial = p.connected.Sub(p.get_conn).Milliseconds()
+	}
+	if !p.tls_done.IsZero() {
+		res.TLS = since(p.tls_done, p.tls_start)
+	}
+	if !p.first_byte.IsZero() {
+		res.FirstByte = since(p.first_byte, p.wrote)
+	}
+	return res
 }
 
 // @addr:  'http://domain.tld'
-func (v2 V2utils) test_http(addr string, include_response bool) (err error, duration int64, body []byte) {
+// @client:  made by test_client
+// @phases:  timing of the request phases, see TestPhases
+func (v2 V2utils) test_http(client *http.Client, addr string, include_response bool) (err error, duration int64, body []byte, phases *TestPhases) {
 	var req *http.Request; var resp *http.Response;
 	ctx, cancel := context.WithTimeout (context.Background(), TestTimeout)
 	defer cancel()
@@ -148,14 +417,18 @@ func (v2 V2utils) test_http(addr string, include_response bool) (err error, dura
 	if nil != err {
 		return
 	}
-	req = req.WithContext(ctx)
+	timer := &phase_timer{}
+	ctx = context.WithValue(ctx, phase_timer_key{}, timer)
+	req = req.WithContext(httptrace.WithClientTrace(ctx, timer.trace()))
 
-	httpTransport := http.Transport{DialContext: v2.CustomDial}
-	client := http.Client{Transport: &httpTransport}
-	if resp, err = client.Do(req); nil != err {
+	resp, err = client.Do(req)
+	phases = timer.get()
+	if nil != err {
 		return
 	}
 	defer resp.Body.Close();
+	// The rest of body, to reuse the connection
+	defer io.Copy(io.Discard, io.LimitReader(resp.Body, 64 << 10));
 
 	deadline, _ := ctx.Deadline()
 	duration = (TestTimeout - time.Until(deadline)).Milliseconds();
@@ -181,10 +454,15 @@ func (v2 *V2utils) Test_URL(url string, tester ConnectivityTester_I) (error, *Te
 
 // Tests a config file @path
 func (v2 *V2utils) Test_CFG(path string, tester ConnectivityTester_I) (error, *TestResult) {
-	if e := v2.Apply_template(path); nil != e || nil == v2.CFG {
+	if e := v2.Load_CFG(path); nil != e || nil == v2.CFG {
 		return e, nil
 	}
+	v2.prepare_test_cfg()
+	return v2.doTest(tester);
+}
 
+// Makes @v2.CFG (a config file) suitable for internal use
+func (v2 *V2utils) prepare_test_cfg() {
 	// We should eliminate 'inbounds' section for testing,
 	// as the inbound proxy port(s), may be in use, so may
 	// lead to true-negative results.
@@ -196,6 +474,4 @@ func (v2 *V2utils) Test_CFG(path string, tester ConnectivityTester_I) (error, *T
 	} else {
 		v2.CFG.LogConfig = &conf.LogConfig{LogLevel: "none"}
 	}
-
-	return v2.doTest(tester);
 }
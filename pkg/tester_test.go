@@ -0,0 +1,249 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+package pkg
+
+import (
+	"io"
+	"net"
+	"time"
+	"testing"
+	"sync/atomic"
+	"crypto/tls"
+	"crypto/x509"
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	internet "github.com/xtls/xray-core/transport/internet"
+)
+
+func TestLatency_Stats(t *testing.T) {
+	st := Latency_Stats([]int64{100, 120, 80, 300, 90, 110, 100, 95, 105}, 10)
+	expected := LatencyStats{Rounds: 10, Min: 80, Median: 100, P95: 300, Jitter: 66, Loss: 10}
+	if expected != *st {
+		t.Fatalf("expected %+v, got %+v\n", expected, *st)
+	}
+	st = Latency_Stats([]int64{10, 20}, 2)
+	if 15 != st.Median || 20 != st.P95 || 10 != st.Jitter || 0 != st.Loss {
+		t.Fatalf("unexpected stats %+v\n", *st)
+	}
+	if st = Latency_Stats(nil, 4); 100 != st.Loss {
+		t.Fatalf("expected 100%% loss, got %+v\n", *st)
+	}
+}
+
+func TestTestRounds(t *testing.T) {
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if http.StateNew == state {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	TestRounds = 5
+	defer func() { TestRounds = 0 }()
+	v2 := &V2utils{}
+	v2.Apply_template_bystr(`{"log": {"loglevel": "none"}, "outbounds": [{"protocol": "freedom"}]}`)
+	err, res := v2.doTest(&Simple_Contester{endpoints: []string{srv.URL}})
+	if nil != err {
+		t.Fatalf("test failed: %v\n", err)
+	}
+	// The first probe is not a sample
+	if nil == res.Stats || 5 != res.Stats.Rounds || 0 != res.Stats.Loss {
+		t.Fatalf("unexpected stats %+v\n", res.Stats)
+	}
+	if res.Stats.Min > res.Stats.Median || res.Stats.Median > res.Stats.P95 {
+		t.Fatalf("unordered stats %+v\n", *res.Stats)
+	}
+	if n := conns.Load(); 1 != n {
+		t.Fatalf("probes were not kept alive, %d connections\n", n)
+	}
+}
+
+// Clock of the phases, advanced by the test servers
+type test_clock struct {
+	ms atomic.Int64
+}
+
+func (c *test_clock) now() time.Time {
+	return time.UnixMilli(c.ms.Load())
+}
+
+// Advances @clock while connecting
+type test_clock_dialer struct {
+	internet.SystemDialer
+	clock *test_clock
+}
+
+func (d *test_clock_dialer) Dial(ctx context.Context, src xnet.Address, dst xnet.Destination, sockopt *internet.SocketConfig) (net.Conn, error) {
+	d.clock.ms.Add(20)
+	return d.SystemDialer.Dial(ctx, src, dst, sockopt)
+}
+
+func TestTest_Phases(t *testing.T) {
+	clock := &test_clock{}
+	phase_now = clock.now
+	defer func() { phase_now = time.Now }()
+	// Connecting to the proxy takes 20ms
+	system := phase_system_dialer.SystemDialer
+	phase_system_dialer.SystemDialer = &test_clock_dialer{SystemDialer: system, clock: clock}
+	defer func() { phase_system_dialer.SystemDialer = system }()
+
+	ip := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "127.0.0.1")
+	}))
+	defer ip.Close()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clock.ms.Add(40)
+	}))
+	srv.TLS = &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			clock.ms.Add(30)
+			return nil, nil
+		},
+	}
+	srv.StartTLS()
+	defer srv.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	test_tls_config = &tls.Config{RootCAs: roots}
+	defer func() { test_tls_config = nil }()
+
+	// The proxy handshake takes 100ms
+	var count atomic.Int32
+	proxy := connect_proxy(&count)
+	defer proxy.Close()
+	handler := proxy.Config.Handler
+	proxy.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clock.ms.Add(100)
+		handler.ServeHTTP(w, r)
+	})
+
+	v2 := &V2utils{}
+	url := "http://" + proxy.Listener.Addr().String()
+	err, res := v2.Test_URL(url, &IP_Contester{endpoints: []string{ip.URL}, phases: srv.URL})
+	if nil != err {
+		t.Fatalf("test failed: %v\n", err)
+	}
+	if "127.0.0.1" != res.IP || nil == res.Phases {
+		t.Fatalf("unexpected result %+v\n", *res)
+	}
+	// The proxy handshake goes with the client hello
+	expected := TestPhases{Dial: 20, TLS: 130, FirstByte: 40}
+	if expected != *res.Phases {
+		t.Fatalf("expected phases %+v, got %+v\n", expected, *res.Phases)
+	}
+
+	// Failed phases probe does not fail the test
+	srv.Close()
+	err, res = v2.Test_URL(url, &IP_Contester{endpoints: []string{ip.URL}, phases: srv.URL})
+	if nil != err || nil != res.Phases {
+		t.Fatalf("unexpected result of failed phases probe: %v, %+v\n", err, res)
+	}
+}
+
+// Forced outbound tag of CustomDial must bypass the routing
+func TestCustomDial_Forced(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	v2 := &V2utils{}
+	v2.Apply_template_bystr(`{
+	    "log": {"loglevel": "none"},
+	    "outbounds": [
+	        {"tag": "block", "protocol": "blackhole"},
+	        {"tag": "tagged", "protocol": "freedom"}
+	    ],
+	    "routing": {"rules": [{"type": "field", "network": "tcp,udp", "outboundTag": "block"}]}
+	}`)
+	if e := v2.Run_Xray(); nil != e {
+		t.Fatalf("Run_Xray failed: %v\n", e)
+	}
+	defer v2.Kill_Xray()
+
+	TestTimeout = time.Second
+	defer func() { TestTimeout = 10 * time.Second }()
+	client := v2.test_client()
+	defer client.CloseIdleConnections()
+	if err, _, _, _ := v2.test_http(client, srv.URL, false); nil == err || 0 != hits.Load() {
+		t.Fatalf("routing was not applied: %v\n", err)
+	}
+	v2.outbound_tag = "tagged"
+	client = v2.test_client() // the client has its own copy of v2
+	defer client.CloseIdleConnections()
+	if err, _, _, _ := v2.test_http(client, srv.URL, false); nil != err || 1 != hits.Load() {
+		t.Fatalf("request did not go through the tagged outbound: %v\n", err)
+	}
+}
+
+// Stub tester, returns the given result
+type stub_Contester struct {
+	err error
+	res *TestResult
+}
+func (tester *stub_Contester) Test(v2 *V2utils) (error, *TestResult) {
+	if nil != tester.res {
+		res := *tester.res
+		return tester.err, &res
+	}
+	return tester.err, nil
+}
+
+func TestIPv6_Contester(t *testing.T) {
+	v4_ok := &stub_Contester{res: &TestResult{Duration: 10, IP: "1.2.3.4"}}
+	v4_fail := &stub_Contester{err: Not_Responding_Error}
+	tests := []struct {
+		name string
+		v4, v6 *stub_Contester
+		fail bool
+		dur int64
+		ip, ip6 string
+	}{
+		{"both", v4_ok, &stub_Contester{res: &TestResult{Duration: 20, IP: "2001:db8::1"}},
+			false, 10, "1.2.3.4", "2001:db8::1"},
+		{"v6 fails", v4_ok, &stub_Contester{err: Not_Responding_Error},
+			false, 10, "1.2.3.4", ""},
+		{"both fail", v4_fail, &stub_Contester{err: Not_Responding_Error},
+			true, 0, "", ""},
+		{"v4 fails, v6 succeeds", v4_fail,
+			&stub_Contester{res: &TestResult{Duration: 20, IP: "2001:db8::1"}},
+			false, 20, "", "2001:db8::1"},
+		{"IPv4 by the v6 endpoint", v4_ok,
+			&stub_Contester{res: &TestResult{Duration: 20, IP: "5.6.7.8"}},
+			false, 10, "1.2.3.4", ""},
+		{"v4 fails, IPv4 by the v6 endpoint", v4_fail,
+			&stub_Contester{res: &TestResult{Duration: 20, IP: "5.6.7.8"}},
+			true, 0, "", ""},
+		{"v4 fails, invalid IP by the v6 endpoint", v4_fail,
+			&stub_Contester{res: &TestResult{Duration: 20, IP: ""}},
+			true, 0, "", ""},
+		{"invalid IP by the v6 endpoint", v4_ok,
+			&stub_Contester{res: &TestResult{Duration: 20, IP: ""}},
+			false, 10, "1.2.3.4", ""},
+	}
+	for _, tc := range tests {
+		tester := &IPv6_Contester{v4: tc.v4, v6: tc.v6}
+		err, res := tester.Test(nil)
+		if tc.fail {
+			if nil == err {
+				t.Fatalf("%s: expected error, got %+v\n", tc.name, res)
+			}
+			continue
+		}
+		if nil != err {
+			t.Fatalf("%s: unexpected error: %v\n", tc.name, err)
+		}
+		if tc.dur != res.Duration || tc.ip != res.IP || tc.ip6 != res.IPv6 {
+			t.Fatalf("%s: unexpected result %+v\n", tc.name, *res)
+		}
+	}
+}